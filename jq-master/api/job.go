@@ -0,0 +1,212 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+)
+
+const (
+	DefaultPriority = 0
+	DefaultMaxRetry = 10
+	DefaultTimeout  = 30 // seconds
+)
+
+// JobRequest is the body of POST /job.
+// Optional fields are pointers so that omitted ones can be told apart from zero.
+type JobRequest struct {
+	Name       string                 `msgpack:"name"`
+	Argument   map[string]interface{} `msgpack:"argument"`
+	Priority   *int                   `msgpack:"priority"`
+	MaxRetry   *int                   `msgpack:"max_retry"`
+	KeepResult bool                   `msgpack:"keep_result"`
+	Timeout    *int                   `msgpack:"timeout"` // seconds
+
+	DependsOn  []string `msgpack:"depends_on,omitempty"`
+	UniqueKey  string   `msgpack:"unique_key,omitempty"`
+	UniqueMode string   `msgpack:"unique_mode,omitempty"`
+	RunAt      string   `msgpack:"run_at,omitempty"` // ISO 8601
+	Delay      int      `msgpack:"delay,omitempty"`  // seconds
+}
+
+type JobResponse struct {
+	JobID string `msgpack:"job_id"`
+}
+
+// JobStatusResponse is the body of GET /job/{job_id}.
+type JobStatusResponse struct {
+	Status     string      `msgpack:"status"`
+	WorkerID   *string     `msgpack:"worker_id"`
+	Result     interface{} `msgpack:"result"`
+	Error      interface{} `msgpack:"error"`
+	Message    string      `msgpack:"message"`
+	RetryCount int         `msgpack:"retry_count"`
+}
+
+// jobSettings validates the optional settings of a job, filling in defaults.
+func jobSettings(priorityOpt, maxRetryOpt, timeoutOpt *int) (priority, maxRetry int, timeout time.Duration, err error) {
+	priority = DefaultPriority
+	if priorityOpt != nil {
+		priority = *priorityOpt
+	}
+	if priority < math.MinInt32 || priority > math.MaxInt32 {
+		return 0, 0, 0, errors.New("priority must fit in a 32-bit integer")
+	}
+	maxRetry = DefaultMaxRetry
+	if maxRetryOpt != nil {
+		maxRetry = *maxRetryOpt
+	}
+	if maxRetry < 0 {
+		return 0, 0, 0, errors.New("max_retry must not be negative")
+	}
+	timeoutSeconds := DefaultTimeout
+	if timeoutOpt != nil {
+		timeoutSeconds = *timeoutOpt
+	}
+	if timeoutSeconds <= 0 {
+		return 0, 0, 0, errors.New("timeout must be positive")
+	}
+	return priority, maxRetry, time.Duration(timeoutSeconds) * time.Second, nil
+}
+
+// toJob validates the request and builds the job to enqueue, filling in defaults.
+func (r *JobRequest) toJob(jobID string, now time.Time) (scheduler.Job, error) {
+	if r.Name == "" {
+		return scheduler.Job{}, errors.New("name is required")
+	}
+	if r.Argument == nil {
+		return scheduler.Job{}, errors.New("argument is required")
+	}
+
+	priority, maxRetry, timeout, err := jobSettings(r.Priority, r.MaxRetry, r.Timeout)
+	if err != nil {
+		return scheduler.Job{}, err
+	}
+	if r.Delay < 0 {
+		return scheduler.Job{}, errors.New("delay must not be negative")
+	}
+	switch r.UniqueMode {
+	case "", scheduler.UniqueWhileQueued, scheduler.UniqueWhileQueuedOrRunning:
+	default:
+		return scheduler.Job{}, fmt.Errorf("unknown unique_mode: %s", r.UniqueMode)
+	}
+
+	runAt, err := transport.JobRegisterRequest{RunAt: r.RunAt, Delay: r.Delay}.ScheduledAt(now)
+	if err != nil {
+		return scheduler.Job{}, err
+	}
+
+	return scheduler.Job{
+		ID:           jobID,
+		Name:         r.Name,
+		Argument:     r.Argument,
+		Priority:     priority,
+		MaxRetry:     maxRetry,
+		KeepResult:   r.KeepResult,
+		Timeout:      timeout,
+		RegisteredAt: now,
+		RunAt:        runAt,
+		DependsOn:    r.DependsOn,
+		UniqueKey:    r.UniqueKey,
+		UniqueMode:   r.UniqueMode,
+	}, nil
+}
+
+func (s *Server) handleAddJob(w http.ResponseWriter, req *http.Request) {
+	var jobReq JobRequest
+	if err := decodeBody(req, &jobReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := jobReq.toJob(uuid.NewString(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// a duplicate of a pending job answers with the id of that job
+	jobID, err := s.sched.AddJob(req.Context(), job)
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownParent),
+		errors.Is(err, scheduler.ErrParentFailed),
+		errors.Is(err, scheduler.ErrDependencyCycle):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		internalError(w, err)
+		return
+	}
+
+	writeMsgpack(w, http.StatusCreated, JobResponse{JobID: jobID})
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, req *http.Request, jobID string) {
+	state, err := s.sched.GetJobState(req.Context(), jobID)
+	if errors.Is(err, scheduler.ErrJobNotFound) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	s.writeJobStatus(w, req, jobID, state)
+}
+
+// isFinalStatus reports whether a job in the status will not run again.
+func isFinalStatus(status string) bool {
+	switch status {
+	case scheduler.JobStatusDone, scheduler.JobStatusError, scheduler.JobStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// writeJobStatus responds with the state of the job, handing out its kept result if it finished.
+func (s *Server) writeJobStatus(w http.ResponseWriter, req *http.Request, jobID string, state scheduler.JobState) {
+	resp := JobStatusResponse{
+		Status:     state.Status,
+		Error:      state.Error,
+		Message:    state.Message,
+		RetryCount: state.RetryCount,
+	}
+	if state.WorkerID != "" {
+		resp.WorkerID = &state.WorkerID
+	}
+
+	if isFinalStatus(state.Status) {
+		// a kept result is handed out only once
+		result, err := s.sched.TakeJobResult(req.Context(), jobID)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if result != nil && result.Type == transport.JobResultSuccess {
+			resp.Result = result.Result
+		}
+	}
+
+	writeMsgpack(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleCancelJob(w http.ResponseWriter, req *http.Request, jobID string) {
+	err := s.sched.CancelJob(req.Context(), jobID)
+	switch {
+	case errors.Is(err, scheduler.ErrJobNotFound):
+		http.NotFound(w, req)
+	case errors.Is(err, scheduler.ErrJobNotCancellable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		internalError(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+)
+
+const (
+	DefaultPollingTimeout = 5 // seconds
+	resubscribeInterval   = 1 * time.Second
+)
+
+// resultWaiters lets any number of requests wait for jobs to finish
+// while sharing a single subscription to published results.
+type resultWaiters struct {
+	mutex   sync.Mutex
+	waiters map[string]map[chan struct{}]struct{} // job id -> channels closed when the job finishes
+}
+
+func newResultWaiters() *resultWaiters {
+	return &resultWaiters{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// wait returns a channel that is closed once a result of the job is published.
+// stop must be called when the caller is no longer waiting.
+func (w *resultWaiters) wait(jobID string) (done <-chan struct{}, stop func()) {
+	ch := make(chan struct{})
+
+	w.mutex.Lock()
+	if w.waiters[jobID] == nil {
+		w.waiters[jobID] = make(map[chan struct{}]struct{})
+	}
+	w.waiters[jobID][ch] = struct{}{}
+	w.mutex.Unlock()
+
+	return ch, func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+
+		delete(w.waiters[jobID], ch)
+		if len(w.waiters[jobID]) == 0 {
+			delete(w.waiters, jobID)
+		}
+	}
+}
+
+// notify wakes up everyone waiting for the job.
+func (w *resultWaiters) notify(jobID string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for ch := range w.waiters[jobID] {
+		close(ch)
+	}
+	delete(w.waiters, jobID)
+}
+
+// WatchResults wakes up polling requests as results are published, until ctx is done.
+func (s *Server) WatchResults(ctx context.Context) {
+	for {
+		err := s.tran.SubscribeResults(ctx, func(result transport.JobResult) {
+			s.waiters.notify(result.JobID)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("result subscription ended: %v", err)
+		time.Sleep(resubscribeInterval)
+	}
+}
+
+func (s *Server) handlePollJob(w http.ResponseWriter, req *http.Request, jobID string) {
+	timeout := DefaultPollingTimeout
+	if v := req.URL.Query().Get("timeout"); v != "" {
+		var err error
+		timeout, err = strconv.Atoi(v)
+		if err != nil || timeout < 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// start waiting before looking at the state so that a result published in between is not missed
+	done, stop := s.waiters.wait(jobID)
+	defer stop()
+
+	state, err := s.sched.GetJobState(req.Context(), jobID)
+	if errors.Is(err, scheduler.ErrJobNotFound) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if isFinalStatus(state.Status) {
+		s.writeJobStatus(w, req, jobID, state)
+		return
+	}
+
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		// a result may have been missed while resubscribing
+	case <-req.Context().Done():
+		return
+	}
+
+	state, err = s.sched.GetJobState(req.Context(), jobID)
+	if errors.Is(err, scheduler.ErrJobNotFound) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if !isFinalStatus(state.Status) {
+		http.Error(w, http.StatusText(http.StatusRequestTimeout), http.StatusRequestTimeout)
+		return
+	}
+	s.writeJobStatus(w, req, jobID, state)
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/cron"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+)
+
+// RecurringJobRequest is the body of PUT /recurring/{id}, and a value of the file given by RECURRING_JOBS_FILE.
+// Optional fields are pointers so that omitted ones can be told apart from zero.
+type RecurringJobRequest struct {
+	Schedule   string                 `msgpack:"schedule" json:"schedule"` // cron expression
+	Timezone   string                 `msgpack:"timezone" json:"timezone"` // IANA name; empty for UTC
+	Name       string                 `msgpack:"name" json:"name"`
+	Argument   map[string]interface{} `msgpack:"argument" json:"argument"`
+	Priority   *int                   `msgpack:"priority" json:"priority"`
+	MaxRetry   *int                   `msgpack:"max_retry" json:"max_retry"`
+	KeepResult bool                   `msgpack:"keep_result" json:"keep_result"`
+	Timeout    *int                   `msgpack:"timeout" json:"timeout"` // seconds
+	Paused     bool                   `msgpack:"paused" json:"paused"`
+}
+
+// RecurringJobResponse is an element of the body of GET /recurring.
+type RecurringJobResponse struct {
+	ID         string                 `msgpack:"id"`
+	Schedule   string                 `msgpack:"schedule"`
+	Timezone   string                 `msgpack:"timezone"`
+	Name       string                 `msgpack:"name"`
+	Argument   map[string]interface{} `msgpack:"argument"`
+	Priority   int                    `msgpack:"priority"`
+	MaxRetry   int                    `msgpack:"max_retry"`
+	KeepResult bool                   `msgpack:"keep_result"`
+	Timeout    int                    `msgpack:"timeout"` // seconds
+	Paused     bool                   `msgpack:"paused"`
+	NextRun    *string                `msgpack:"next_run"` // ISO 8601; nil while paused
+}
+
+// toRecurringJob validates the request and builds the definition to register, filling in defaults.
+func (r *RecurringJobRequest) toRecurringJob(id string) (scheduler.RecurringJob, error) {
+	if r.Name == "" {
+		return scheduler.RecurringJob{}, errors.New("name is required")
+	}
+	if r.Argument == nil {
+		return scheduler.RecurringJob{}, errors.New("argument is required")
+	}
+	if _, err := cron.Parse(r.Schedule); err != nil {
+		return scheduler.RecurringJob{}, fmt.Errorf("invalid schedule: %w", err)
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil {
+		return scheduler.RecurringJob{}, fmt.Errorf("invalid timezone: %w", err)
+	}
+	priority, maxRetry, timeout, err := jobSettings(r.Priority, r.MaxRetry, r.Timeout)
+	if err != nil {
+		return scheduler.RecurringJob{}, err
+	}
+
+	return scheduler.RecurringJob{
+		ID:         id,
+		Schedule:   r.Schedule,
+		Timezone:   r.Timezone,
+		Name:       r.Name,
+		Argument:   r.Argument,
+		Priority:   priority,
+		MaxRetry:   maxRetry,
+		KeepResult: r.KeepResult,
+		Timeout:    timeout,
+		Paused:     r.Paused,
+	}, nil
+}
+
+// DecodeRecurringJobs reads recurring job definitions from a JSON object mapping definition ids to RecurringJobRequest.
+func DecodeRecurringJobs(r io.Reader) ([]scheduler.RecurringJob, error) {
+	var reqs map[string]RecurringJobRequest
+	if err := json.NewDecoder(r).Decode(&reqs); err != nil {
+		return nil, err
+	}
+
+	rjs := make([]scheduler.RecurringJob, 0, len(reqs))
+	for id, req := range reqs {
+		rj, err := req.toRecurringJob(id)
+		if err != nil {
+			return nil, fmt.Errorf("recurring job %s: %w", id, err)
+		}
+		rjs = append(rjs, rj)
+	}
+	return rjs, nil
+}
+
+func (s *Server) handleListRecurringJobs(w http.ResponseWriter, req *http.Request) {
+	infos, err := s.sched.ListRecurringJobs(req.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	resp := make([]RecurringJobResponse, 0, len(infos))
+	for _, info := range infos {
+		rjResp := RecurringJobResponse{
+			ID:         info.ID,
+			Schedule:   info.Schedule,
+			Timezone:   info.Timezone,
+			Name:       info.Name,
+			Argument:   info.Argument,
+			Priority:   info.Priority,
+			MaxRetry:   info.MaxRetry,
+			KeepResult: info.KeepResult,
+			Timeout:    int(info.Timeout / time.Second),
+			Paused:     info.Paused,
+		}
+		if !info.NextRun.IsZero() {
+			nextRun := info.NextRun.UTC().Format(time.RFC3339)
+			rjResp.NextRun = &nextRun
+		}
+		resp = append(resp, rjResp)
+	}
+	writeMsgpack(w, http.StatusOK, resp)
+}
+
+func (s *Server) handlePutRecurringJob(w http.ResponseWriter, req *http.Request, id string) {
+	var rjReq RecurringJobRequest
+	if err := decodeBody(req, &rjReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rj, err := rjReq.toRecurringJob(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.sched.AddRecurringJob(req.Context(), rj); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRecurringJobChange responds to a change of an existing definition.
+func handleRecurringJobChange(w http.ResponseWriter, req *http.Request, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrRecurringJobNotFound):
+		http.NotFound(w, req)
+	case err != nil:
+		internalError(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleTriggerRecurringJob(w http.ResponseWriter, req *http.Request, id string) {
+	jobID, err := s.sched.TriggerRecurringJob(req.Context(), id)
+	if errors.Is(err, scheduler.ErrRecurringJobNotFound) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	writeMsgpack(w, http.StatusCreated, JobResponse{JobID: jobID})
+}
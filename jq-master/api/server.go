@@ -0,0 +1,182 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	ContentTypeMsgpack = transport.ContentTypeMsgpack
+	maxRequestBodySize = 1 << 20
+)
+
+var errInvalidBody = errors.New("invalid request body")
+
+// Server serves the HTTP API described in openapi.yaml.
+type Server struct {
+	sched    *scheduler.Scheduler
+	tran     *transport.Conn
+	liveness *transport.LivenessTracker // may be nil to not watch pulling workers
+	isLeader func() bool                // may be nil if this master always leads
+	waiters  *resultWaiters
+}
+
+func NewServer(sched *scheduler.Scheduler, tran *transport.Conn, liveness *transport.LivenessTracker, isLeader func() bool) *Server {
+	return &Server{
+		sched:    sched,
+		tran:     tran,
+		liveness: liveness,
+		isLeader: isLeader,
+		waiters:  newResultWaiters(),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+
+	switch {
+	case path == "/worker":
+		if s.isLeader != nil && !s.isLeader() {
+			// workers are tracked in the memory of the leading master
+			http.Error(w, "not the leading master", http.StatusServiceUnavailable)
+			return
+		}
+		switch req.Method {
+		case http.MethodPost:
+			s.handleAddWorker(w, req)
+		case http.MethodDelete:
+			s.handleRemoveWorker(w, req)
+		default:
+			methodNotAllowed(w, http.MethodPost, http.MethodDelete)
+		}
+	case path == "/done":
+		switch req.Method {
+		case http.MethodPost:
+			s.handleDone(w, req)
+		default:
+			methodNotAllowed(w, http.MethodPost)
+		}
+	case path == "/job":
+		switch req.Method {
+		case http.MethodPost:
+			s.handleAddJob(w, req)
+		default:
+			methodNotAllowed(w, http.MethodPost)
+		}
+	case strings.HasPrefix(path, "/job/") && strings.HasSuffix(path, "/polling"):
+		jobID := strings.TrimSuffix(strings.TrimPrefix(path, "/job/"), "/polling")
+		if jobID == "" || strings.Contains(jobID, "/") {
+			http.NotFound(w, req)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			s.handlePollJob(w, req, jobID)
+		default:
+			methodNotAllowed(w, http.MethodGet)
+		}
+	case strings.HasPrefix(path, "/job/"):
+		jobID := strings.TrimPrefix(path, "/job/")
+		if jobID == "" || strings.Contains(jobID, "/") {
+			http.NotFound(w, req)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			s.handleGetJob(w, req, jobID)
+		case http.MethodDelete:
+			s.handleCancelJob(w, req, jobID)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+	case path == "/recurring":
+		switch req.Method {
+		case http.MethodGet:
+			s.handleListRecurringJobs(w, req)
+		default:
+			methodNotAllowed(w, http.MethodGet)
+		}
+	case strings.HasPrefix(path, "/recurring/"):
+		id, action, _ := strings.Cut(strings.TrimPrefix(path, "/recurring/"), "/")
+		if id == "" || strings.Contains(action, "/") {
+			http.NotFound(w, req)
+			return
+		}
+		switch action {
+		case "":
+			switch req.Method {
+			case http.MethodPut:
+				s.handlePutRecurringJob(w, req, id)
+			case http.MethodDelete:
+				handleRecurringJobChange(w, req, s.sched.RemoveRecurringJob(req.Context(), id))
+			default:
+				methodNotAllowed(w, http.MethodPut, http.MethodDelete)
+			}
+		case "pause", "resume", "trigger":
+			if req.Method != http.MethodPost {
+				methodNotAllowed(w, http.MethodPost)
+				return
+			}
+			switch action {
+			case "pause":
+				handleRecurringJobChange(w, req, s.sched.PauseRecurringJob(req.Context(), id))
+			case "resume":
+				handleRecurringJobChange(w, req, s.sched.ResumeRecurringJob(req.Context(), id))
+			case "trigger":
+				s.handleTriggerRecurringJob(w, req, id)
+			}
+		default:
+			http.NotFound(w, req)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// decodeBody decodes a MessagePack request body into v.
+func decodeBody(req *http.Request, v interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxRequestBodySize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxRequestBodySize {
+		return errInvalidBody
+	}
+	if err := msgpack.Unmarshal(body, v); err != nil {
+		return errInvalidBody
+	}
+	return nil
+}
+
+// writeMsgpack writes v as a MessagePack response body.
+func writeMsgpack(w http.ResponseWriter, status int, v interface{}) {
+	body, err := msgpack.Marshal(v)
+	if err != nil {
+		log.Printf("error encoding response: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeMsgpack)
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func internalError(w http.ResponseWriter, err error) {
+	log.Printf("error handling request: %v", err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
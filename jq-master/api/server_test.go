@@ -0,0 +1,372 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lightpub-dev/lightjq/jq-master/api"
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func newTestServer() *api.Server {
+	// requests rejected before reaching the scheduler never touch redis
+	return api.NewServer(scheduler.NewScheduler(nil, nil), nil, nil, nil)
+}
+
+func postJob(t *testing.T, srv http.Handler, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	bodyBin, err := msgpack.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/job", bytes.NewReader(bodyBin))
+	req.Header.Set("Content-Type", api.ContentTypeMsgpack)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAddJobValidation(t *testing.T) {
+	srv := newTestServer()
+
+	cases := map[string]interface{}{
+		"missing name":      map[string]interface{}{"argument": map[string]interface{}{}},
+		"missing argument":  map[string]interface{}{"name": "job"},
+		"priority too low":  map[string]interface{}{"name": "job", "argument": map[string]interface{}{}, "priority": int64(math.MinInt32) - 1},
+		"priority too high": map[string]interface{}{"name": "job", "argument": map[string]interface{}{}, "priority": int64(math.MaxInt32) + 1},
+		"negative retry":    map[string]interface{}{"name": "job", "argument": map[string]interface{}{}, "max_retry": -1},
+		"zero timeout":      map[string]interface{}{"name": "job", "argument": map[string]interface{}{}, "timeout": 0},
+		"bad run_at":        map[string]interface{}{"name": "job", "argument": map[string]interface{}{}, "run_at": "tomorrow"},
+		"bad unique_mode":   map[string]interface{}{"name": "job", "argument": map[string]interface{}{}, "unique_mode": "forever"},
+		"not a map":         "job",
+	}
+
+	for name, body := range cases {
+		if rec := postJob(t, srv, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+}
+
+func TestAddJobMalformedBody(t *testing.T) {
+	srv := newTestServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/job", bytes.NewReader([]byte{0xc1}))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	srv := newTestServer()
+
+	cases := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/job", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/job/abc", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/job/abc/def", http.StatusNotFound},
+		{http.MethodPost, "/job/abc/polling", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/job/abc/polling?timeout=-1", http.StatusBadRequest},
+		{http.MethodGet, "/job/abc/polling?timeout=soon", http.StatusBadRequest},
+		{http.MethodPost, "/recurring", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/recurring/abc", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/recurring/abc/pause", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/recurring/abc/stop", http.StatusNotFound},
+		{http.MethodGet, "/unknown", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.path, c.want, rec.Code)
+		}
+	}
+}
+
+func TestDoneValidation(t *testing.T) {
+	srv := newTestServer()
+
+	cases := map[string]struct {
+		workerID string
+		body     interface{}
+	}{
+		"missing worker-id": {"", map[string]interface{}{"job_id": "job", "type": "success"}},
+		"missing job_id":    {"worker", map[string]interface{}{"type": "success"}},
+		"unknown type":      {"worker", map[string]interface{}{"job_id": "job", "type": "finished"}},
+		"unknown reason":    {"worker", map[string]interface{}{"job_id": "job", "type": "failure", "reason": "bored"}},
+	}
+
+	for name, c := range cases {
+		bodyBin, err := msgpack.Marshal(c.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/done", bytes.NewReader(bodyBin))
+		if c.workerID != "" {
+			req.Header.Set(api.HeaderWorkerID, c.workerID)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+}
+
+func registerWorker(t *testing.T, srv http.Handler, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	bodyBin, err := msgpack.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/worker", bytes.NewReader(bodyBin)))
+	return rec
+}
+
+func removeWorker(srv http.Handler, workerID string) int {
+	req := httptest.NewRequest(http.MethodDelete, "/worker", nil)
+	if workerID != "" {
+		req.Header.Set(api.HeaderWorkerID, workerID)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWorkerRegistrationValidation(t *testing.T) {
+	srv := newTestServer()
+
+	for name, body := range map[string]interface{}{
+		"missing name":     map[string]interface{}{"max_jobs": 1},
+		"missing max_jobs": map[string]interface{}{"name": "worker"},
+	} {
+		if rec := registerWorker(t, srv, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+
+	if code := removeWorker(srv, ""); code != http.StatusBadRequest {
+		t.Errorf("missing header: expected 400, got %d", code)
+	}
+	if code := removeWorker(srv, "unknown"); code != http.StatusNotFound {
+		t.Errorf("unknown worker: expected 404, got %d", code)
+	}
+}
+
+func TestWorkerRegistration(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	tran := transport.NewConn(r)
+	srv := api.NewServer(scheduler.NewScheduler(r, tran), tran, nil, nil)
+
+	rec := registerWorker(t, srv, map[string]interface{}{"name": "worker", "max_jobs": 2})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+	var resp api.WorkerResponse
+	if err := msgpack.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.WorkerID == "" {
+		t.Fatal("expected a worker id")
+	}
+
+	if code := removeWorker(srv, resp.WorkerID); code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", code)
+	}
+	if code := removeWorker(srv, resp.WorkerID); code != http.StatusNotFound {
+		t.Errorf("removing twice: expected 404, got %d", code)
+	}
+}
+
+func TestWorkerRegistrationOnStandby(t *testing.T) {
+	srv := api.NewServer(scheduler.NewScheduler(nil, nil), nil, nil, func() bool { return false })
+
+	rec := registerWorker(t, srv, map[string]interface{}{"name": "worker", "max_jobs": 2})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+}
+
+func TestAddJobNegativePriority(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	tran := transport.NewConn(r)
+	srv := api.NewServer(scheduler.NewScheduler(r, tran), tran, nil, nil)
+
+	rec := postJob(t, srv, map[string]interface{}{"name": "job", "argument": map[string]interface{}{}, "priority": -5})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+	var resp api.JobResponse
+	if err := msgpack.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.ZRem(context.Background(), scheduler.RScoredJobSet, resp.JobID)
+		r.Del(context.Background(), transport.MakeJobKey(resp.JobID), "jq:jobState:"+resp.JobID)
+	})
+
+	score, err := r.ZScore(context.Background(), scheduler.RScoredJobSet, resp.JobID).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score != -5 {
+		t.Errorf("expected the job to be queued with priority -5, got %v", score)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	tran := transport.NewConn(r)
+	srv := api.NewServer(scheduler.NewScheduler(r, tran), tran, nil, nil)
+
+	rec := postJob(t, srv, map[string]interface{}{"name": "job", "argument": map[string]interface{}{}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+	var resp api.JobResponse
+	if err := msgpack.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.ZRem(context.Background(), scheduler.RCancelledJobs, resp.JobID)
+		r.Del(context.Background(), transport.MakeJobKey(resp.JobID), "jq:jobState:"+resp.JobID)
+	})
+
+	cancelJob := func(jobID string) int {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/job/"+jobID, nil))
+		return rec.Code
+	}
+	if code := cancelJob(resp.JobID); code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", code)
+	}
+	if code := cancelJob(resp.JobID); code != http.StatusConflict {
+		t.Errorf("cancelling twice: expected 409, got %d", code)
+	}
+	if code := cancelJob("unknown"); code != http.StatusNotFound {
+		t.Errorf("unknown job: expected 404, got %d", code)
+	}
+}
+
+func TestRecurringJobs(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	tran := transport.NewConn(r)
+	srv := api.NewServer(scheduler.NewScheduler(r, tran), tran, nil, nil)
+	id := "api-" + t.Name()
+	t.Cleanup(func() {
+		r.HDel(context.Background(), scheduler.RRecurringJobs, id)
+		r.ZRem(context.Background(), scheduler.RRecurringNextRun, id)
+	})
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var bodyBin []byte
+		if body != nil {
+			var err error
+			if bodyBin, err = msgpack.Marshal(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(bodyBin)))
+		return rec
+	}
+	list := func() []api.RecurringJobResponse {
+		rec := send(http.MethodGet, "/recurring", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("listing: expected 200, got %d", rec.Code)
+		}
+		var resp []api.RecurringJobResponse
+		if err := msgpack.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, rj := range resp {
+			if rj.ID == id {
+				return []api.RecurringJobResponse{rj}
+			}
+		}
+		return nil
+	}
+
+	if code := send(http.MethodPut, "/recurring/"+id, map[string]interface{}{"schedule": "not cron", "name": "job", "argument": map[string]interface{}{}}).Code; code != http.StatusBadRequest {
+		t.Errorf("bad schedule: expected 400, got %d", code)
+	}
+	if code := send(http.MethodPut, "/recurring/"+id, map[string]interface{}{"schedule": "0 * * * *", "timezone": "Asia/Tokyo", "name": "job", "argument": map[string]interface{}{}}).Code; code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	rjs := list()
+	if len(rjs) != 1 || rjs[0].Timezone != "Asia/Tokyo" || rjs[0].Timeout != api.DefaultTimeout || rjs[0].NextRun == nil {
+		t.Fatalf("expected the recurring job to be listed with its next run, got %+v", rjs)
+	}
+
+	if code := send(http.MethodPost, "/recurring/"+id+"/pause", nil).Code; code != http.StatusNoContent {
+		t.Errorf("pausing: expected 204, got %d", code)
+	}
+	if rjs := list(); len(rjs) != 1 || !rjs[0].Paused || rjs[0].NextRun != nil {
+		t.Errorf("expected the recurring job to be paused, got %+v", rjs)
+	}
+	if code := send(http.MethodPost, "/recurring/"+id+"/resume", nil).Code; code != http.StatusNoContent {
+		t.Errorf("resuming: expected 204, got %d", code)
+	}
+	if rjs := list(); len(rjs) != 1 || rjs[0].Paused || rjs[0].NextRun == nil {
+		t.Errorf("expected the recurring job to be resumed, got %+v", rjs)
+	}
+
+	rec := send(http.MethodPost, "/recurring/"+id+"/trigger", nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("triggering: expected 201, got %d", rec.Code)
+	}
+	var resp api.JobResponse
+	if err := msgpack.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.ZRem(context.Background(), scheduler.RScoredJobSet, resp.JobID)
+		r.Del(context.Background(), transport.MakeJobKey(resp.JobID), "jq:jobState:"+resp.JobID)
+	})
+	if _, err := r.ZScore(ctx, scheduler.RScoredJobSet, resp.JobID).Result(); err != nil {
+		t.Errorf("expected the triggered job to be queued (%v)", err)
+	}
+
+	if code := send(http.MethodDelete, "/recurring/"+id, nil).Code; code != http.StatusNoContent {
+		t.Errorf("removing: expected 204, got %d", code)
+	}
+	for _, path := range []string{"/recurring/" + id + "/pause", "/recurring/" + id + "/trigger"} {
+		if code := send(http.MethodPost, path, nil).Code; code != http.StatusNotFound {
+			t.Errorf("%s after removal: expected 404, got %d", path, code)
+		}
+	}
+	if code := send(http.MethodDelete, "/recurring/"+id, nil).Code; code != http.StatusNotFound {
+		t.Errorf("removing twice: expected 404, got %d", code)
+	}
+}
+
+func TestDecodeRecurringJobs(t *testing.T) {
+	rjs, err := api.DecodeRecurringJobs(strings.NewReader(`{"nightly": {"schedule": "0 3 * * *", "name": "report", "argument": {"full": true}, "max_retry": 2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rjs) != 1 || rjs[0].ID != "nightly" || rjs[0].Name != "report" || rjs[0].MaxRetry != 2 || rjs[0].Priority != api.DefaultPriority {
+		t.Errorf("unexpected recurring jobs: %+v", rjs)
+	}
+
+	if _, err := api.DecodeRecurringJobs(strings.NewReader(`{"nightly": {"schedule": "0 3 * * *", "argument": {}}}`)); err == nil {
+		t.Error("expected a recurring job without a name to be rejected")
+	}
+}
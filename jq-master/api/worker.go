@@ -0,0 +1,167 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+)
+
+const HeaderWorkerID = "worker-id"
+
+// WorkerRequest is the body of POST /worker.
+type WorkerRequest struct {
+	Name    string `msgpack:"name"`
+	MaxJobs int    `msgpack:"max_jobs"`
+	URL     string `msgpack:"url,omitempty"` // set to have jobs pushed to <url>/worker-job
+}
+
+type WorkerResponse struct {
+	WorkerID string `msgpack:"worker_id"`
+}
+
+func (r *WorkerRequest) toWorker(workerID string) (*scheduler.Worker, error) {
+	if r.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if r.MaxJobs <= 0 {
+		return nil, errors.New("max_jobs must be positive")
+	}
+	if r.URL != "" {
+		return scheduler.NewPushWorker(workerID, r.Name, r.MaxJobs, r.URL), nil
+	}
+	return scheduler.NewWorker(workerID, r.Name, r.MaxJobs), nil
+}
+
+func (s *Server) handleAddWorker(w http.ResponseWriter, req *http.Request) {
+	var workerReq WorkerRequest
+	if err := decodeBody(req, &workerReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	worker, err := workerReq.toWorker(uuid.NewString())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.sched.AddWorker(req.Context(), worker); err != nil {
+		internalError(w, err)
+		return
+	}
+	if s.liveness != nil && !worker.IsPush() {
+		// pulling workers have to keep pinging
+		s.liveness.Track(worker.ID, time.Now())
+	}
+	log.Printf("worker registered: %s (%s)", worker.ID, worker.WorkerName)
+	writeMsgpack(w, http.StatusCreated, WorkerResponse{WorkerID: worker.ID})
+}
+
+func (s *Server) handleRemoveWorker(w http.ResponseWriter, req *http.Request) {
+	workerID := req.Header.Get(HeaderWorkerID)
+	if workerID == "" {
+		http.Error(w, "worker-id header is required", http.StatusBadRequest)
+		return
+	}
+
+	err := s.sched.RemoveWorker(req.Context(), workerID)
+	if errors.Is(err, scheduler.ErrWorkerNotFound) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if s.liveness != nil {
+		s.liveness.Forget(workerID)
+	}
+	log.Printf("worker unregistered: %s", workerID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DoneRequest is the body of POST /done.
+type DoneRequest struct {
+	JobID string `msgpack:"job_id"`
+	Type  string `msgpack:"type"`
+
+	// When type == success
+	Result interface{} `msgpack:"result"`
+
+	// When type == failure
+	Reason      string      `msgpack:"reason"`
+	ShouldRetry bool        `msgpack:"should_retry"`
+	Message     string      `msgpack:"message"`
+	Error       interface{} `msgpack:"error"`
+}
+
+// toResult validates the request and builds the result reported by workerID.
+func (r *DoneRequest) toResult(workerID string, now time.Time) (transport.JobResult, error) {
+	if r.JobID == "" {
+		return transport.JobResult{}, errors.New("job_id is required")
+	}
+
+	result := transport.JobResult{
+		JobID:      r.JobID,
+		Type:       r.Type,
+		FinishedAt: now.Format(time.RFC3339),
+		WorkerID:   workerID,
+	}
+	switch r.Type {
+	case transport.JobResultSuccess:
+		result.Result = r.Result
+	case transport.JobResultFailure:
+		switch r.Reason {
+		case transport.JobFailureReasonTimeout, transport.JobFailureReasonOther, transport.JobFailureReasonCancelled:
+		default:
+			return transport.JobResult{}, errors.New("invalid reason")
+		}
+		result.Reason = r.Reason
+		result.ShouldRetry = r.ShouldRetry
+		result.Message = r.Message
+		result.Error = r.Error
+	default:
+		return transport.JobResult{}, errors.New("invalid type")
+	}
+	return result, nil
+}
+
+func (s *Server) handleDone(w http.ResponseWriter, req *http.Request) {
+	workerID := req.Header.Get(HeaderWorkerID)
+	if workerID == "" {
+		http.Error(w, "worker-id header is required", http.StatusBadRequest)
+		return
+	}
+
+	var doneReq DoneRequest
+	if err := decodeBody(req, &doneReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := doneReq.toResult(workerID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	processing, err := s.sched.IsProcessing(req.Context(), result.JobID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if !processing {
+		http.NotFound(w, req)
+		return
+	}
+
+	// results are processed in order with the ones reported through redis
+	if err := s.tran.SubmitResult(req.Context(), result); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,84 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/cron"
+)
+
+func mustParse(t *testing.T, expr string) *cron.Schedule {
+	t.Helper()
+	s, err := cron.Parse(expr)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", expr, err)
+	}
+	return s
+}
+
+func TestNext(t *testing.T) {
+	// Wednesday
+	base := time.Date(2024, 5, 1, 10, 30, 15, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2024, 5, 2, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon", time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 12 15 * fri", time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5,10 0 * jan,dec *", time.Date(2024, 12, 1, 0, 5, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		if got := mustParse(t, c.expr).Next(base); !got.Equal(c.want) {
+			t.Errorf("%q: expected %v, got %v", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestNextTimezone(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	s := mustParse(t, "0 9 * * *")
+
+	// 2024-05-01 00:30 UTC is 09:30 in Tokyo
+	got := s.Next(time.Date(2024, 5, 1, 0, 30, 0, 0, time.UTC).In(tokyo))
+	want := time.Date(2024, 5, 2, 9, 0, 0, 0, tokyo)
+	if !got.Equal(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s := mustParse(t, "0 0 30 2 *")
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Errorf("expected no next time, got %v", got)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	exprs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+	}
+	for _, expr := range exprs {
+		if _, err := cron.Parse(expr); err == nil {
+			t.Errorf("expected error parsing %q", expr)
+		}
+	}
+}
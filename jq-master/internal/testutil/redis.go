@@ -0,0 +1,24 @@
+// Package testutil holds helpers shared by the tests of jq-master.
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SetupRedis connects to the Redis server on localhost:6379 and skips the
+// test when none is available. The client is closed when the test ends.
+func SetupRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	r := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	if err := r.Ping(context.Background()).Err(); err != nil {
+		r.Close()
+		t.Skipf("redis not available: %v", err)
+	}
+	t.Cleanup(func() {
+		r.Close()
+	})
+	return r
+}
@@ -0,0 +1,173 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RLeaderLock  = "jq:leader"      // id of the leading master, expiring with its lease
+	RLeaderFence = "jq:leaderFence" // fencing token, incremented on every election
+
+	DefaultLeaseTTL = 10 * time.Second
+)
+
+var ErrNotLeader = errors.New("not the leader anymore")
+
+// KEYS[1]: lock, KEYS[2]: fence
+// ARGV[1]: candidate id, ARGV[2]: lease ttl in millis
+// returns the fencing token of the new term, or 0 if someone else leads
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+// KEYS[1]: lock
+// ARGV[1]: leader id, ARGV[2]: lease ttl in millis
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// KEYS[1]: lock
+// ARGV[1]: leader id
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+return 0
+`)
+
+// KEYS[1]: lock, KEYS[2]: fence
+// ARGV[1]: leader id, ARGV[2]: fencing token
+var checkScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] and redis.call("GET", KEYS[2]) == ARGV[2] then
+	return 1
+end
+return 0
+`)
+
+// Fence identifies one term of leadership.
+type Fence struct {
+	id    string
+	Token int64
+}
+
+// Check returns ErrNotLeader if the term is over, i.e. another master may be leading.
+// c is usually the client of the elector; a transaction watching Keys may be passed
+// to make its writes depend on the check.
+func (f *Fence) Check(ctx context.Context, c redis.Scripter) error {
+	ok, err := checkScript.Run(ctx, c, []string{RLeaderLock, RLeaderFence}, f.id, f.Token).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// Keys lists the keys the fence depends on.
+func (f *Fence) Keys() []string {
+	return []string{RLeaderLock, RLeaderFence}
+}
+
+// Elector lets masters sharing a redis take turns leading, using a lease lock.
+type Elector struct {
+	r   *redis.Client
+	id  string
+	ttl time.Duration
+
+	leading atomic.Bool
+}
+
+func NewElector(r *redis.Client, id string, ttl time.Duration) *Elector {
+	return &Elector{r: r, id: id, ttl: ttl}
+}
+
+// IsLeader reports whether this master currently holds the lease.
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run campaigns for leadership until ctx is done.
+// While leading, lead is run with a context that is cancelled as soon as the lease cannot be renewed.
+// A standby takes over at most one lease ttl and one campaign interval after the leader stopped renewing.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context, fence *Fence)) {
+	interval := e.ttl / 3
+
+	for {
+		token, err := acquireScript.Run(ctx, e.r, []string{RLeaderLock, RLeaderFence}, e.id, e.ttl.Milliseconds()).Int64()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error campaigning for leadership: %v", err)
+		}
+		if token > 0 {
+			e.leadTerm(ctx, &Fence{id: e.id, Token: token}, lead)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (e *Elector) leadTerm(ctx context.Context, fence *Fence, lead func(ctx context.Context, fence *Fence)) {
+	log.Printf("leading as %s (term %d)", e.id, fence.Token)
+	interval := e.ttl / 3
+
+	termCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	e.leading.Store(true)
+	go func() {
+		defer close(done)
+		lead(termCtx, fence)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+renew:
+	for {
+		select {
+		case <-ctx.Done():
+			break renew
+		case <-done:
+			break renew
+		case <-ticker.C:
+			// give up before the lease could lapse unnoticed
+			renewCtx, cancelRenew := context.WithTimeout(ctx, interval)
+			ok, err := renewScript.Run(renewCtx, e.r, []string{RLeaderLock}, e.id, e.ttl.Milliseconds()).Int()
+			cancelRenew()
+			if err != nil {
+				log.Printf("error renewing leadership: %v", err)
+				break renew
+			}
+			if ok == 0 {
+				log.Printf("leadership lost (term %d)", fence.Token)
+				break renew
+			}
+		}
+	}
+
+	e.leading.Store(false)
+	cancel()
+	<-done
+
+	// let a standby take over right away
+	if err := releaseScript.Run(context.Background(), e.r, []string{RLeaderLock}, e.id).Err(); err != nil {
+		log.Printf("error releasing leadership: %v", err)
+	}
+	log.Printf("stepped down (term %d)", fence.Token)
+}
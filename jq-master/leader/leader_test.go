@@ -0,0 +1,53 @@
+package leader_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/leader"
+)
+
+func TestStandbyTakesOver(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	r.Del(context.Background(), leader.RLeaderLock)
+
+	ttl := 300 * time.Millisecond
+	terms := make(chan int64, 2)
+	lead := func(ctx context.Context, fence *leader.Fence) {
+		terms <- fence.Token
+		<-ctx.Done()
+	}
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	first := leader.NewElector(r, "first", ttl)
+	go first.Run(firstCtx, lead)
+
+	var firstTerm int64
+	select {
+	case firstTerm = <-terms:
+	case <-time.After(2 * time.Second):
+		t.Fatal("nobody took the lead")
+	}
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	second := leader.NewElector(r, "second", ttl)
+	go second.Run(secondCtx, lead)
+
+	time.Sleep(2 * ttl)
+	if !first.IsLeader() || second.IsLeader() {
+		t.Fatal("the standby took over while the leader was renewing")
+	}
+
+	stopFirst()
+	select {
+	case secondTerm := <-terms:
+		if secondTerm <= firstTerm {
+			t.Errorf("expected a newer fencing token than %d, got %d", firstTerm, secondTerm)
+		}
+	case <-time.After(2 * ttl):
+		t.Fatal("the standby did not take over")
+	}
+}
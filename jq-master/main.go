@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/lightpub-dev/lightjq/jq-master/api"
+	"github.com/lightpub-dev/lightjq/jq-master/leader"
 	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
 	"github.com/lightpub-dev/lightjq/jq-master/transport"
 	"github.com/redis/go-redis/v9"
@@ -15,16 +21,18 @@ import (
 type JQMaster struct {
 	r *redis.Client
 
-	conn  *transport.Conn
-	sched *scheduler.Scheduler
+	conn     *transport.Conn
+	sched    *scheduler.Scheduler
+	liveness *transport.LivenessTracker
 }
 
-func NewJQMaster(r *redis.Client) *JQMaster {
+func NewJQMaster(r *redis.Client, pingGrace time.Duration) *JQMaster {
 	conn := transport.NewConn(r)
 	return &JQMaster{
-		r:     r,
-		conn:  conn,
-		sched: scheduler.NewScheduler(r, conn),
+		r:        r,
+		conn:     conn,
+		sched:    scheduler.NewScheduler(r, conn),
+		liveness: transport.NewLivenessTracker(conn, pingGrace),
 	}
 }
 
@@ -32,23 +40,66 @@ func (m *JQMaster) Run(ctx context.Context) error {
 	workerChan := make(chan transport.WorkerRegisterRequest)
 	jobChan := make(chan transport.JobRegisterRequest)
 	resultChan := make(chan transport.JobResult)
+	livenessChan := make(chan transport.LivenessEvent)
+
+	// pick up where the previous run left off
+	workers, err := m.sched.Recover(ctx)
+	if err != nil {
+		return err
+	}
+	for _, worker := range workers {
+		if !worker.IsPush() {
+			// restored workers leave unless they are still pinging
+			m.liveness.Track(worker.ID, time.Now())
+		}
+	}
 
 	go m.conn.PollNewClient(ctx, workerChan)
 	go m.conn.PollNewJob(ctx, jobChan)
 	go m.conn.PollNewResult(ctx, resultChan)
+	go m.liveness.Run(ctx, livenessChan)
 
-	go m.sched.DistributeJobs(ctx)
+	go m.sched.DistributeJobs(ctx, resultChan)
+	go m.sched.ReapTimedOutJobs(ctx, resultChan)
+	go m.sched.PromoteDelayedJobs(ctx)
+	go m.sched.RunRecurringJobs(ctx)
 
 	for {
 		select {
 		case newWorker := <-workerChan:
-			m.sched.AddWorker(scheduler.NewWorker(newWorker.ID, newWorker.WorkerName, newWorker.Processes))
-			go transport.NewPingChecker(m.conn, newWorker.ID).PingCheckLoop(ctx, func(failure transport.PingFailure) {
-				log.Printf("dropping worker %s (ping check failed)", failure.WorkerID)
-				m.sched.RemoveWorker(failure.WorkerID)
-			})
+			worker := scheduler.NewWorker(newWorker.ID, newWorker.WorkerName, newWorker.Processes)
+			if newWorker.URL != "" {
+				worker = scheduler.NewPushWorker(newWorker.ID, newWorker.WorkerName, newWorker.Processes, newWorker.URL)
+			}
+			if err := m.sched.AddWorker(ctx, worker); err != nil {
+				log.Printf("error adding worker %s: %v", newWorker.ID, err)
+				continue
+			}
+			if !worker.IsPush() {
+				// pulling workers have to keep pinging
+				m.liveness.Track(worker.ID, time.Now())
+			}
+		case event := <-livenessChan:
+			switch event.Type {
+			case transport.WorkerJoined:
+				if !m.sched.HasWorker(event.WorkerID) {
+					log.Printf("unregistered worker %s is pinging", event.WorkerID)
+				}
+			case transport.WorkerLeft:
+				log.Printf("dropping worker %s (no ping)", event.WorkerID)
+				err := m.sched.RemoveWorker(ctx, event.WorkerID)
+				if err != nil && !errors.Is(err, scheduler.ErrWorkerNotFound) {
+					log.Printf("error removing worker %s: %v", event.WorkerID, err)
+				}
+			}
 		case newJob := <-jobChan:
-			if err := m.sched.AddJob(ctx, scheduler.Job{
+			now := time.Now()
+			runAt, err := newJob.ScheduledAt(now)
+			if err != nil {
+				log.Printf("error adding job %s: %v", newJob.ID, err)
+				continue
+			}
+			jobID, err := m.sched.AddJob(ctx, scheduler.Job{
 				ID:           newJob.ID,
 				Name:         newJob.Name,
 				Argument:     newJob.Argument,
@@ -56,9 +107,16 @@ func (m *JQMaster) Run(ctx context.Context) error {
 				MaxRetry:     newJob.MaxRetry,
 				KeepResult:   newJob.KeepResult,
 				Timeout:      time.Duration(newJob.Timeout) * time.Second,
-				RegisteredAt: time.Now(),
-			}); err != nil {
+				RegisteredAt: now,
+				RunAt:        runAt,
+				DependsOn:    newJob.DependsOn,
+				UniqueKey:    newJob.UniqueKey,
+				UniqueMode:   newJob.UniqueMode,
+			})
+			if err != nil {
 				log.Printf("error adding job: %v", err)
+			} else if jobID != newJob.ID {
+				log.Printf("job %s not added; job %s is already pending", newJob.ID, jobID)
 			}
 		case newResult := <-resultChan:
 			if err := m.sched.ProcessResult(ctx, newResult); err != nil {
@@ -70,12 +128,38 @@ func (m *JQMaster) Run(ctx context.Context) error {
 	}
 }
 
+// registerRecurringJobs registers the recurring jobs defined in the JSON file at path,
+// replacing the definitions with the same ids (including whether they are paused).
+func registerRecurringJobs(ctx context.Context, sched *scheduler.Scheduler, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rjs, err := api.DecodeRecurringJobs(f)
+	if err != nil {
+		return err
+	}
+	for _, rj := range rjs {
+		if err := sched.AddRecurringJob(ctx, rj); err != nil {
+			return fmt.Errorf("recurring job %s: %w", rj.ID, err)
+		}
+	}
+	log.Printf("registered %d recurring jobs from %s", len(rjs), path)
+	return nil
+}
+
 func main() {
 	redisAddr := os.Getenv("REDIS_ADDR")
 	redisPort := os.Getenv("REDIS_PORT")
 	redisUser := os.Getenv("REDIS_USER")
 	redisPassword := os.Getenv("REDIS_PASSWORD")
 	redisDatabaseStr := os.Getenv("REDIS_DATABASE")
+	httpAddr := os.Getenv("HTTP_ADDR")
+	pingGraceStr := os.Getenv("WORKER_PING_GRACE")
+	leaseTTLStr := os.Getenv("LEADER_LEASE_TTL")
+	recurringJobsFile := os.Getenv("RECURRING_JOBS_FILE")
 
 	if redisAddr == "" {
 		redisAddr = "localhost"
@@ -83,6 +167,27 @@ func main() {
 	if redisPort == "" {
 		redisPort = "6379"
 	}
+	if httpAddr == "" {
+		httpAddr = ":8080"
+	}
+
+	pingGrace := transport.DefaultPingGrace
+	if pingGraceStr != "" {
+		d, err := time.ParseDuration(pingGraceStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid WORKER_PING_GRACE: %s", pingGraceStr)
+		}
+		pingGrace = d
+	}
+
+	leaseTTL := leader.DefaultLeaseTTL
+	if leaseTTLStr != "" {
+		d, err := time.ParseDuration(leaseTTLStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid LEADER_LEASE_TTL: %s", leaseTTLStr)
+		}
+		leaseTTL = d
+	}
 
 	redisDatabase := 0
 	if redisDatabaseStr != "" {
@@ -100,11 +205,31 @@ func main() {
 		DB:       redisDatabase,
 	})
 
-	master := NewJQMaster(r)
+	master := NewJQMaster(r, pingGrace)
+	elector := leader.NewElector(r, uuid.NewString(), leaseTTL)
 	ctx := context.Background()
-	log.Printf("jq-master started")
-	if err := master.Run(ctx); err != nil {
-		log.Fatalf("error running jq-master: %v", err)
-		os.Exit(1)
+
+	if recurringJobsFile != "" {
+		if err := registerRecurringJobs(ctx, master.sched, recurringJobsFile); err != nil {
+			log.Fatalf("invalid RECURRING_JOBS_FILE: %v", err)
+		}
 	}
+
+	server := api.NewServer(master.sched, master.conn, master.liveness, elector.IsLeader)
+	go server.WatchResults(ctx)
+	go func() {
+		log.Printf("http api listening on %s", httpAddr)
+		if err := http.ListenAndServe(httpAddr, server); err != nil {
+			log.Fatalf("error running http api: %v", err)
+		}
+	}()
+
+	log.Printf("jq-master started")
+	// only the leader dispatches jobs and processes results; the others stand by
+	elector.Run(ctx, func(ctx context.Context, fence *leader.Fence) {
+		master.sched.SetFence(fence)
+		if err := master.Run(ctx); err != nil {
+			log.Printf("error running jq-master: %v", err)
+		}
+	})
 }
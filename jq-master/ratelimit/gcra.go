@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// GCRA implements the generic cell rate algorithm.
+// It behaves like a token bucket but only keeps the theoretical arrival time of the next request.
+type GCRA struct {
+	mutex    sync.Mutex
+	interval time.Duration // Time between requests at the sustained rate
+	burst    time.Duration // How far ahead of the sustained rate requests may be
+	tat      time.Time     // Theoretical arrival time
+	never    bool          // set for a rate or burst that allows no request at all
+
+	clock Clock
+}
+
+// NewGCRA creates a new GCRA limiter allowing rate requests per second with bursts of up to burst requests.
+// A limiter whose rate is not positive or whose burst is below 1 allows no request.
+func NewGCRA(rate float64, burst int64, clock Clock) *GCRA {
+	if rate <= 0 || burst < 1 {
+		return &GCRA{never: true, clock: clock}
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	return &GCRA{
+		interval: interval,
+		burst:    interval * time.Duration(burst),
+		tat:      clock.Now(),
+		clock:    clock,
+	}
+}
+
+// allowN advances the theoretical arrival time by n requests if they conform,
+// and otherwise returns how long until they would.
+func (g *GCRA) allowN(now time.Time, n int64) (bool, time.Duration) {
+	if g.never {
+		return false, Never
+	}
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(g.interval * time.Duration(n))
+	if wait := newTat.Sub(now) - g.burst; wait > 0 {
+		return false, wait
+	}
+
+	g.tat = newTat
+	return true, 0
+}
+
+// Allow checks if a request can be allowed, advancing the theoretical arrival time if so.
+func (g *GCRA) Allow() bool {
+	return g.AllowN(1)
+}
+
+// AllowN checks if n requests can be allowed at once, advancing the theoretical arrival time if so.
+func (g *GCRA) AllowN(n int64) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	ok, _ := g.allowN(g.clock.Now(), n)
+	return ok
+}
+
+// Reserve advances the theoretical arrival time and returns 0 if a request is allowed.
+// Otherwise it changes nothing and returns how long until a request will be allowed.
+func (g *GCRA) Reserve() time.Duration {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	_, wait := g.allowN(g.clock.Now(), 1)
+	return wait
+}
+
+// NextAt returns the earliest time a request would be allowed.
+func (g *GCRA) NextAt() time.Time {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.clock.Now()
+	if g.never {
+		return now.Add(Never)
+	}
+	if next := g.tat.Add(g.interval - g.burst); next.After(now) {
+		return next
+	}
+	return now
+}
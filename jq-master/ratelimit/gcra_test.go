@@ -0,0 +1,77 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/ratelimit"
+)
+
+func TestGCRABurst(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewGCRA(1, 3, clk) // 1 request per second, burst of 3
+
+	ok1 := limiter.Allow()
+	ok2 := limiter.Allow()
+	ok3 := limiter.Allow()
+	ok4 := limiter.Allow()
+	if !ok1 || !ok2 || !ok3 || ok4 {
+		t.Error("Incorrect allowance behavior in burst case")
+	}
+}
+
+func TestGCRASustainedRate(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewGCRA(0.5, 1, clk) // 1 request every 2 seconds
+
+	if !limiter.Allow() {
+		t.Error("Expected the first request to be allowed")
+	}
+
+	clk.Add(1 * time.Second)
+	if limiter.Allow() {
+		t.Error("Expected request to be denied before the interval elapsed")
+	}
+
+	clk.Add(1 * time.Second)
+	if !limiter.Allow() {
+		t.Error("Expected request to be allowed after the interval elapsed")
+	}
+}
+
+func TestGCRAReservation(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewGCRA(2, 2, clk) // 2 requests per second, burst of 2
+
+	if limiter.AllowN(3) {
+		t.Error("Expected a burst larger than allowed to be denied")
+	}
+	if !limiter.AllowN(2) {
+		t.Error("Expected a burst of 2 to be allowed")
+	}
+
+	if d := limiter.Reserve(); d != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms, got %v", d)
+	}
+
+	clk.Add(500 * time.Millisecond)
+	if !limiter.NextAt().Equal(clk.Now()) || limiter.Reserve() != 0 {
+		t.Error("Expected a request to be allowed at the reported time")
+	}
+}
+
+func TestGCRAWithoutRate(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	for _, limiter := range []*ratelimit.GCRA{
+		ratelimit.NewGCRA(0, 3, clk),
+		ratelimit.NewGCRA(-1, 3, clk),
+		ratelimit.NewGCRA(1, 0, clk),
+	} {
+		if limiter.Allow() {
+			t.Error("Expected no request to be allowed")
+		}
+		if wait := limiter.Reserve(); wait != ratelimit.Never {
+			t.Errorf("Expected to wait forever, got %v", wait)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package ratelimit
 
-import "time"
+import (
+	"math"
+	"sync"
+	"time"
+)
 
 // Used to get the current time for rate limiting.
 type Clock interface {
@@ -11,6 +15,10 @@ var (
 	DefaultClock Clock = &RealClock{}
 )
 
+// Never is the wait reported by a limiter that will not allow a request again,
+// e.g. a token bucket that does not refill because its rate is not positive.
+const Never = time.Duration(math.MaxInt64)
+
 // RealClock is a real-time clock.
 type RealClock struct{}
 
@@ -22,9 +30,18 @@ func (r *RealClock) Now() time.Time {
 type RateLimiter interface {
 	// Allow checks if a request can be allowed, consuming a token if so.
 	Allow() bool
+	// AllowN checks if n requests can be allowed at once, consuming n tokens if so.
+	AllowN(n int64) bool
+	// Reserve consumes a token and returns 0 if one is available.
+	// Otherwise it consumes nothing and returns how long until a token will be available.
+	Reserve() time.Duration
+	// NextAt returns the earliest time a request would be allowed.
+	NextAt() time.Time
 }
 
+// RateLimiterCollection is safe for concurrent use.
 type RateLimiterCollection struct {
+	mutex sync.RWMutex
 	// Rate limiters for different keys
 	limitters map[string]RateLimiter
 }
@@ -38,13 +55,58 @@ func NewRateLimitterCollection() *RateLimiterCollection {
 
 // AddRateLimitter adds a rate limiter for a specific key.
 func (rlc *RateLimiterCollection) AddRateLimitter(key string, rl RateLimiter) {
+	rlc.mutex.Lock()
+	defer rlc.mutex.Unlock()
+
 	rlc.limitters[key] = rl
 }
 
-func (rlc *RateLimiterCollection) Allow(key string) bool {
+// RemoveRateLimitter removes the rate limiter for a specific key.
+func (rlc *RateLimiterCollection) RemoveRateLimitter(key string) {
+	rlc.mutex.Lock()
+	defer rlc.mutex.Unlock()
+
+	delete(rlc.limitters, key)
+}
+
+func (rlc *RateLimiterCollection) get(key string) (RateLimiter, bool) {
+	rlc.mutex.RLock()
+	defer rlc.mutex.RUnlock()
+
 	rl, ok := rlc.limitters[key]
+	return rl, ok
+}
+
+func (rlc *RateLimiterCollection) Allow(key string) bool {
+	rl, ok := rlc.get(key)
 	if !ok {
 		return true
 	}
 	return rl.Allow()
 }
+
+func (rlc *RateLimiterCollection) AllowN(key string, n int64) bool {
+	rl, ok := rlc.get(key)
+	if !ok {
+		return true
+	}
+	return rl.AllowN(n)
+}
+
+func (rlc *RateLimiterCollection) Reserve(key string) time.Duration {
+	rl, ok := rlc.get(key)
+	if !ok {
+		return 0
+	}
+	return rl.Reserve()
+}
+
+// NextAt returns the earliest time a request for the key would be allowed.
+// The zero time means the key has no rate limiter, i.e. always now.
+func (rlc *RateLimiterCollection) NextAt(key string) time.Time {
+	rl, ok := rlc.get(key)
+	if !ok {
+		return time.Time{}
+	}
+	return rl.NextAt()
+}
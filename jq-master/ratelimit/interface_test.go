@@ -1,7 +1,10 @@
 package ratelimit_test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/lightpub-dev/lightjq/jq-master/ratelimit"
 )
@@ -14,6 +17,21 @@ func (s *SimpleLimiter) Allow() bool {
 	return s.allowed
 }
 
+func (s *SimpleLimiter) AllowN(n int64) bool {
+	return s.allowed
+}
+
+func (s *SimpleLimiter) Reserve() time.Duration {
+	if s.allowed {
+		return 0
+	}
+	return 1 * time.Second
+}
+
+func (s *SimpleLimiter) NextAt() time.Time {
+	return time.Now().Add(s.Reserve())
+}
+
 func (s *SimpleLimiter) Set(allowed bool) {
 	s.allowed = allowed
 }
@@ -41,3 +59,47 @@ func TestLimiterNonExistent(t *testing.T) {
 		t.Error("unregistered key should be allowed")
 	}
 }
+
+func TestLimiterCollectionReserve(t *testing.T) {
+	rlc := ratelimit.NewRateLimitterCollection()
+	rlc.AddRateLimitter("key1", &SimpleLimiter{allowed: false})
+
+	if rlc.Reserve("key1") != 1*time.Second {
+		t.Error("Expected key1 to report the delay of its limiter")
+	}
+	if rlc.Reserve("unknown") != 0 {
+		t.Error("unregistered key should not be delayed")
+	}
+	if !rlc.NextAt("unknown").IsZero() {
+		t.Error("unregistered key should have no next time")
+	}
+}
+
+func TestLimiterCollectionConcurrent(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	rlc := ratelimit.NewRateLimitterCollection()
+	rlc.AddRateLimitter("shared", ratelimit.NewTokenBucket(1, 100, clk))
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rlc.AddRateLimitter(fmt.Sprintf("key%d", i), &SimpleLimiter{allowed: true})
+			for j := 0; j < 20; j++ {
+				if rlc.Allow("shared") {
+					mutex.Lock()
+					allowed++
+					mutex.Unlock()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if allowed != 100 {
+		t.Errorf("Expected exactly 100 requests to be allowed, got %d", allowed)
+	}
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// QuotaPeriod is the calendar unit a quota is reset at.
+type QuotaPeriod int
+
+const (
+	QuotaMinute QuotaPeriod = iota
+	QuotaHour
+	QuotaDay
+	QuotaWeek // starting on Monday
+	QuotaMonth
+)
+
+// windowStart returns the start of the period containing t, in t's location.
+func (p QuotaPeriod) windowStart(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch p {
+	case QuotaMinute:
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, t.Location())
+	case QuotaHour:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case QuotaWeek:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, t.Location())
+	case QuotaMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// nextWindowStart returns the start of the period following the one starting at start.
+func (p QuotaPeriod) nextWindowStart(start time.Time) time.Time {
+	year, month, day := start.Date()
+	switch p {
+	case QuotaMinute:
+		return start.Add(time.Minute)
+	case QuotaHour:
+		return time.Date(year, month, day, start.Hour()+1, 0, 0, 0, start.Location())
+	case QuotaWeek:
+		return time.Date(year, month, day+7, 0, 0, 0, 0, start.Location())
+	case QuotaMonth:
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, start.Location())
+	default:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, start.Location())
+	}
+}
+
+// FixedWindowQuota allows at most limit requests per calendar period (e.g. 10,000 per day).
+// Periods follow the wall clock of the given location, so a daily quota resets at local midnight.
+type FixedWindowQuota struct {
+	mutex       sync.Mutex
+	limit       int64
+	period      QuotaPeriod
+	location    *time.Location
+	windowStart time.Time
+	count       int64
+
+	clock Clock
+}
+
+// NewFixedWindowQuota creates a new quota limiter. A nil location means UTC.
+func NewFixedWindowQuota(limit int64, period QuotaPeriod, location *time.Location, clock Clock) *FixedWindowQuota {
+	if location == nil {
+		location = time.UTC
+	}
+	return &FixedWindowQuota{
+		limit:    limit,
+		period:   period,
+		location: location,
+		clock:    clock,
+	}
+}
+
+// advance resets the count when a new period starts
+func (q *FixedWindowQuota) advance(now time.Time) {
+	if windowStart := q.period.windowStart(now); !windowStart.Equal(q.windowStart) {
+		q.windowStart = windowStart
+		q.count = 0
+	}
+}
+
+// Allow checks if a request can be allowed, counting it against the quota if so.
+func (q *FixedWindowQuota) Allow() bool {
+	return q.AllowN(1)
+}
+
+// AllowN checks if n requests can be allowed at once, counting them against the quota if so.
+func (q *FixedWindowQuota) AllowN(n int64) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.advance(q.clock.Now().In(q.location))
+
+	if q.count+n <= q.limit {
+		q.count += n
+		return true
+	}
+
+	return false
+}
+
+// Reserve counts a request and returns 0 if one is allowed.
+// Otherwise it counts nothing and returns how long until the quota resets.
+func (q *FixedWindowQuota) Reserve() time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := q.clock.Now().In(q.location)
+	q.advance(now)
+
+	if q.count < q.limit {
+		q.count++
+		return 0
+	}
+	return q.period.nextWindowStart(q.windowStart).Sub(now)
+}
+
+// NextAt returns the earliest time a request would be allowed.
+func (q *FixedWindowQuota) NextAt() time.Time {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := q.clock.Now().In(q.location)
+	q.advance(now)
+
+	if q.count < q.limit {
+		return now
+	}
+	return q.period.nextWindowStart(q.windowStart)
+}
@@ -0,0 +1,81 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/ratelimit"
+)
+
+func TestDailyQuota(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	clk := &TestableClock{now: time.Date(2024, 5, 1, 23, 0, 0, 0, tokyo)}
+	limiter := ratelimit.NewFixedWindowQuota(2, ratelimit.QuotaDay, tokyo, clk)
+
+	ok1 := limiter.Allow()
+	ok2 := limiter.Allow()
+	ok3 := limiter.Allow()
+	if !ok1 || !ok2 || ok3 {
+		t.Error("Incorrect allowance behavior within a day")
+	}
+
+	// local midnight resets the quota
+	clk.Add(1 * time.Hour)
+	if !limiter.Allow() {
+		t.Error("Expected quota to reset at local midnight")
+	}
+}
+
+func TestMonthlyQuota(t *testing.T) {
+	clk := &TestableClock{now: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)}
+	limiter := ratelimit.NewFixedWindowQuota(1, ratelimit.QuotaMonth, nil, clk)
+
+	if !limiter.Allow() {
+		t.Error("Expected the first request to be allowed")
+	}
+
+	clk.Add(6 * time.Hour)
+	if limiter.Allow() {
+		t.Error("Expected quota to be exhausted for the rest of the month")
+	}
+
+	clk.Add(6 * time.Hour)
+	if !limiter.Allow() {
+		t.Error("Expected quota to reset in the next month")
+	}
+}
+
+func TestWeeklyQuota(t *testing.T) {
+	// Sunday
+	clk := &TestableClock{now: time.Date(2024, 5, 5, 12, 0, 0, 0, time.UTC)}
+	limiter := ratelimit.NewFixedWindowQuota(1, ratelimit.QuotaWeek, nil, clk)
+
+	if !limiter.Allow() || limiter.Allow() {
+		t.Error("Incorrect allowance behavior within a week")
+	}
+
+	// Monday starts a new week
+	clk.Add(12 * time.Hour)
+	if !limiter.Allow() {
+		t.Error("Expected quota to reset on Monday")
+	}
+}
+
+func TestQuotaReservation(t *testing.T) {
+	clk := &TestableClock{now: time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)}
+	limiter := ratelimit.NewFixedWindowQuota(3, ratelimit.QuotaHour, nil, clk)
+
+	if !limiter.AllowN(2) || limiter.AllowN(2) {
+		t.Error("Incorrect AllowN behavior within the quota")
+	}
+	if limiter.Reserve() != 0 {
+		t.Error("Expected the last request to be reserved immediately")
+	}
+
+	if d := limiter.Reserve(); d != 30*time.Minute {
+		t.Errorf("Expected to wait until the next hour, got %v", d)
+	}
+	if next := limiter.NextAt(); !next.Equal(time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected next time: %v", next)
+	}
+}
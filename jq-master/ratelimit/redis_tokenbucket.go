@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "jq:ratelimit:"
+
+// KEYS[1]: bucket state
+// ARGV[1]: rate (tokens per second), ARGV[2]: capacity, ARGV[3]: now (unix millis), ARGV[4]: ttl (millis, 0 = none)
+// ARGV[5]: tokens requested, ARGV[6]: 1 to consume them if available, 0 to only look
+// returns {1 if the tokens were available, millis until they are (-1 for never)}
+var redisTokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[5])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last_refill")
+local tokens = tonumber(state[1])
+local lastRefill = tonumber(state[2])
+if tokens == nil or lastRefill == nil then
+	-- start with a full bucket
+	tokens = capacity
+	lastRefill = now
+end
+
+if rate > 0 then
+	local elapsed = math.max(0, now - lastRefill)
+	tokens = math.min(capacity, tokens + elapsed * rate / 1000)
+end
+
+if tokens < requested then
+	if rate <= 0 then
+		return {0, -1}
+	end
+	return {0, math.ceil((requested - tokens) / rate * 1000)}
+end
+
+if ARGV[6] == "1" then
+	tokens = tokens - requested
+	redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last_refill", tostring(math.max(now, lastRefill)))
+	if tonumber(ARGV[4]) > 0 then
+		redis.call("PEXPIRE", KEYS[1], ARGV[4])
+	end
+end
+return {1, 0}
+`)
+
+// RedisTokenBucket implements a token bucket rate limiter whose state lives in Redis,
+// so that it survives restarts and can be shared by several jq-master instances.
+type RedisTokenBucket struct {
+	r        *redis.Client
+	key      string
+	rate     float64 // Tokens added per second
+	capacity int64   // Maximum tokens in the bucket
+
+	clock Clock
+}
+
+// NewRedisTokenBucket creates a new token bucket stored under the given key (e.g. the constraint name).
+func NewRedisTokenBucket(r *redis.Client, key string, rate float64, capacity int64, clock Clock) *RedisTokenBucket {
+	return &RedisTokenBucket{
+		r:        r,
+		key:      redisKeyPrefix + key,
+		rate:     rate,
+		capacity: capacity,
+		clock:    clock,
+	}
+}
+
+// ttl returns how long the state has to be kept; a bucket left alone that long is full anyway.
+// 0 means the state never expires.
+func (tb *RedisTokenBucket) ttl() int64 {
+	if tb.rate <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(tb.capacity)/tb.rate*1000)) + 1000
+}
+
+// take requests n tokens and returns whether they were available and how long until they are.
+func (tb *RedisTokenBucket) take(n int64, consume bool) (bool, time.Duration, error) {
+	consumeFlag := 0
+	if consume {
+		consumeFlag = 1
+	}
+
+	res, err := redisTokenBucketScript.Run(context.Background(), tb.r, []string{tb.key},
+		tb.rate, tb.capacity, tb.clock.Now().UnixMilli(), tb.ttl(), n, consumeFlag,
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if res[1] < 0 {
+		// the bucket does not refill
+		return false, Never, nil
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+// Allow checks if a request can be allowed, consuming a token if so.
+// Requests are denied while Redis cannot be reached.
+func (tb *RedisTokenBucket) Allow() bool {
+	return tb.AllowN(1)
+}
+
+// AllowN checks if n requests can be allowed at once, consuming n tokens if so.
+// Requests are denied while Redis cannot be reached.
+func (tb *RedisTokenBucket) AllowN(n int64) bool {
+	ok, _, err := tb.take(n, true)
+	if err != nil {
+		log.Printf("error checking rate limit %s: %v", tb.key, err)
+		return false
+	}
+	return ok
+}
+
+// Reserve consumes a token and returns 0 if one is available.
+// Otherwise it consumes nothing and returns how long until a token will be available.
+// While Redis cannot be reached, it asks to try again in a second.
+func (tb *RedisTokenBucket) Reserve() time.Duration {
+	_, wait, err := tb.take(1, true)
+	if err != nil {
+		log.Printf("error checking rate limit %s: %v", tb.key, err)
+		return 1 * time.Second
+	}
+	return wait
+}
+
+// NextAt returns the earliest time a request would be allowed.
+func (tb *RedisTokenBucket) NextAt() time.Time {
+	now := tb.clock.Now()
+	_, wait, err := tb.take(1, false)
+	if err != nil {
+		log.Printf("error checking rate limit %s: %v", tb.key, err)
+		return now.Add(1 * time.Second)
+	}
+	return now.Add(wait)
+}
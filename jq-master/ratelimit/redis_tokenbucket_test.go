@@ -0,0 +1,105 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/ratelimit"
+)
+
+func TestRedisBasicAllowance(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	key := "test:" + t.Name()
+	r.Del(context.Background(), "jq:ratelimit:"+key)
+
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewRedisTokenBucket(r, key, 2, 2, clk) // 2 tokens per second, capacity of 2
+
+	ok1 := limiter.Allow()
+	ok2 := limiter.Allow()
+	ok3 := limiter.Allow()
+	if !ok1 || !ok2 || ok3 {
+		t.Error("Incorrect allowance behavior in basic case")
+	}
+}
+
+func TestRedisRefilling(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	key := "test:" + t.Name()
+	r.Del(context.Background(), "jq:ratelimit:"+key)
+
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewRedisTokenBucket(r, key, 1, 3, clk) // 1 token per second, capacity of 3
+
+	ok1 := limiter.Allow()
+	ok2 := limiter.Allow()
+	ok3 := limiter.Allow()
+	ok4 := limiter.Allow()
+	if !ok1 || !ok2 || !ok3 || ok4 {
+		t.Error("Incorrect allowance behavior in refilling case")
+	}
+
+	clk.Add(1500 * time.Millisecond)
+	ok5 := limiter.Allow()
+	ok6 := limiter.Allow()
+	if !ok5 || ok6 {
+		t.Error("Incorrect allowance behavior after refill")
+	}
+}
+
+func TestRedisSharedState(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	key := "test:" + t.Name()
+	r.Del(context.Background(), "jq:ratelimit:"+key)
+
+	clk := &TestableClock{now: time.Now()}
+	l1 := ratelimit.NewRedisTokenBucket(r, key, 1, 1, clk)
+	l2 := ratelimit.NewRedisTokenBucket(r, key, 1, 1, clk)
+
+	if !l1.Allow() {
+		t.Error("Expected first request to be allowed")
+	}
+	if l2.Allow() {
+		t.Error("Expected the bucket to be shared between limiters with the same key")
+	}
+}
+
+func TestRedisReservation(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	key := "test:" + t.Name()
+	r.Del(context.Background(), "jq:ratelimit:"+key)
+
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewRedisTokenBucket(r, key, 2, 2, clk) // 2 tokens per second, capacity of 2
+
+	if limiter.AllowN(3) || !limiter.AllowN(2) {
+		t.Error("Incorrect AllowN behavior")
+	}
+	if d := limiter.Reserve(); d != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms, got %v", d)
+	}
+
+	clk.Add(500 * time.Millisecond)
+	if !limiter.NextAt().Equal(clk.Now()) || limiter.Reserve() != 0 {
+		t.Error("Expected a token to be available at the reported time")
+	}
+}
+
+func TestRedisWithoutRate(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	key := "test:" + t.Name()
+	r.Del(context.Background(), "jq:ratelimit:"+key)
+
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewRedisTokenBucket(r, key, 0, 1, clk) // never refills
+
+	if !limiter.Allow() {
+		t.Error("Expected the initial token to be allowed")
+	}
+	clk.Add(time.Hour)
+	if wait := limiter.Reserve(); wait != ratelimit.Never {
+		t.Errorf("Expected to wait forever, got %v", wait)
+	}
+}
@@ -0,0 +1,208 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindowLog allows at most limit requests within any window-long period.
+// It remembers the time of every allowed request in the window.
+type SlidingWindowLog struct {
+	mutex  sync.Mutex
+	limit  int
+	window time.Duration
+	log    []time.Time
+
+	clock Clock
+}
+
+// NewSlidingWindowLog creates a new sliding window log limiter.
+func NewSlidingWindowLog(limit int, window time.Duration, clock Clock) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		limit:  limit,
+		window: window,
+		log:    make([]time.Time, 0, limit),
+		clock:  clock,
+	}
+}
+
+// expire forgets requests that left the window
+func (sw *SlidingWindowLog) expire(now time.Time) {
+	windowStart := now.Add(-sw.window)
+	expired := 0
+	for expired < len(sw.log) && !sw.log[expired].After(windowStart) {
+		expired++
+	}
+	sw.log = sw.log[expired:]
+}
+
+// nextAt returns when one more request fits in the window.
+func (sw *SlidingWindowLog) nextAt(now time.Time) time.Time {
+	if sw.limit < 1 {
+		// never; check again after a window
+		return now.Add(sw.window)
+	}
+	if len(sw.log) < sw.limit {
+		return now
+	}
+	return sw.log[len(sw.log)-sw.limit].Add(sw.window)
+}
+
+// Allow checks if a request can be allowed, recording it if so.
+func (sw *SlidingWindowLog) Allow() bool {
+	return sw.AllowN(1)
+}
+
+// AllowN checks if n requests can be allowed at once, recording them if so.
+func (sw *SlidingWindowLog) AllowN(n int64) bool {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	now := sw.clock.Now()
+	sw.expire(now)
+
+	if int64(len(sw.log))+n <= int64(sw.limit) {
+		for i := int64(0); i < n; i++ {
+			sw.log = append(sw.log, now)
+		}
+		return true
+	}
+
+	return false
+}
+
+// Reserve records a request and returns 0 if one is allowed.
+// Otherwise it records nothing and returns how long until a request will be allowed.
+func (sw *SlidingWindowLog) Reserve() time.Duration {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	now := sw.clock.Now()
+	sw.expire(now)
+
+	if len(sw.log) < sw.limit {
+		sw.log = append(sw.log, now)
+		return 0
+	}
+	return sw.nextAt(now).Sub(now)
+}
+
+// NextAt returns the earliest time a request would be allowed.
+func (sw *SlidingWindowLog) NextAt() time.Time {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	now := sw.clock.Now()
+	sw.expire(now)
+	return sw.nextAt(now)
+}
+
+// SlidingWindowCounter approximates a sliding window by weighting the count of the
+// previous fixed window by how much of it still overlaps the sliding window.
+// It needs constant memory regardless of the limit.
+type SlidingWindowCounter struct {
+	mutex         sync.Mutex
+	limit         int64
+	window        time.Duration
+	currentStart  time.Time
+	currentCount  int64
+	previousCount int64
+
+	clock Clock
+}
+
+// NewSlidingWindowCounter creates a new sliding window counter limiter.
+func NewSlidingWindowCounter(limit int64, window time.Duration, clock Clock) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		limit:        limit,
+		window:       window,
+		currentStart: clock.Now(),
+		clock:        clock,
+	}
+}
+
+// advance moves the fixed windows forward to the one containing now
+func (sw *SlidingWindowCounter) advance(now time.Time) {
+	if elapsedWindows := int64(now.Sub(sw.currentStart) / sw.window); elapsedWindows > 0 {
+		if elapsedWindows == 1 {
+			sw.previousCount = sw.currentCount
+		} else {
+			sw.previousCount = 0
+		}
+		sw.currentCount = 0
+		sw.currentStart = sw.currentStart.Add(time.Duration(elapsedWindows) * sw.window)
+	}
+}
+
+func (sw *SlidingWindowCounter) estimate(now time.Time) float64 {
+	previousWeight := 1 - float64(now.Sub(sw.currentStart))/float64(sw.window)
+	return float64(sw.previousCount)*previousWeight + float64(sw.currentCount)
+}
+
+// nextAt returns when the estimated count leaves room for one more request.
+func (sw *SlidingWindowCounter) nextAt(now time.Time) time.Time {
+	if sw.estimate(now)+1 <= float64(sw.limit) {
+		return now
+	}
+
+	// the previous window has to fade out until previous * weight + current + 1 <= limit
+	if room := float64(sw.limit - 1 - sw.currentCount); room >= 0 && sw.previousCount > 0 {
+		weight := room / float64(sw.previousCount)
+		return sw.currentStart.Add(time.Duration((1 - weight) * float64(sw.window)))
+	}
+
+	// the current window is full; wait until it fades out as the previous one
+	if sw.limit < 1 {
+		// never; check again after a window
+		return now.Add(sw.window)
+	}
+	weight := float64(sw.limit-1) / float64(sw.currentCount)
+	return sw.currentStart.Add(sw.window + time.Duration((1-weight)*float64(sw.window)))
+}
+
+// Allow checks if a request can be allowed, counting it if so.
+func (sw *SlidingWindowCounter) Allow() bool {
+	return sw.AllowN(1)
+}
+
+// AllowN checks if n requests can be allowed at once, counting them if so.
+func (sw *SlidingWindowCounter) AllowN(n int64) bool {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	now := sw.clock.Now()
+	sw.advance(now)
+
+	if sw.estimate(now)+float64(n) <= float64(sw.limit) {
+		sw.currentCount += n
+		return true
+	}
+
+	return false
+}
+
+// Reserve counts a request and returns 0 if one is allowed.
+// Otherwise it counts nothing and returns how long until a request will be allowed.
+func (sw *SlidingWindowCounter) Reserve() time.Duration {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	now := sw.clock.Now()
+	sw.advance(now)
+
+	if sw.estimate(now)+1 <= float64(sw.limit) {
+		sw.currentCount++
+		return 0
+	}
+	return sw.nextAt(now).Sub(now)
+}
+
+// NextAt returns the earliest time a request would be allowed.
+func (sw *SlidingWindowCounter) NextAt() time.Time {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	now := sw.clock.Now()
+	sw.advance(now)
+	return sw.nextAt(now)
+}
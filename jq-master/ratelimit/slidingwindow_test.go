@@ -0,0 +1,126 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/ratelimit"
+)
+
+func TestSlidingWindowLogBasic(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewSlidingWindowLog(2, 1*time.Second, clk) // 2 requests per second
+
+	ok1 := limiter.Allow()
+	ok2 := limiter.Allow()
+	ok3 := limiter.Allow()
+	if !ok1 || !ok2 || ok3 {
+		t.Error("Incorrect allowance behavior in basic case")
+	}
+}
+
+func TestSlidingWindowLogSliding(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewSlidingWindowLog(2, 1*time.Second, clk)
+
+	ok1 := limiter.Allow()
+	clk.Add(600 * time.Millisecond)
+	ok2 := limiter.Allow()
+	if !ok1 || !ok2 {
+		t.Error("Expected requests within the limit to be allowed")
+	}
+
+	// only the first request left the window
+	clk.Add(500 * time.Millisecond)
+	ok3 := limiter.Allow()
+	ok4 := limiter.Allow()
+	if !ok3 || ok4 {
+		t.Error("Incorrect allowance behavior after the window slid")
+	}
+}
+
+func TestSlidingWindowCounterBasic(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewSlidingWindowCounter(2, 1*time.Second, clk) // 2 requests per second
+
+	ok1 := limiter.Allow()
+	ok2 := limiter.Allow()
+	ok3 := limiter.Allow()
+	if !ok1 || !ok2 || ok3 {
+		t.Error("Incorrect allowance behavior in basic case")
+	}
+}
+
+func TestSlidingWindowCounterWeighting(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewSlidingWindowCounter(4, 1*time.Second, clk)
+
+	for i := 0; i < 4; i++ {
+		if !limiter.Allow() {
+			t.Fatal("Expected requests within the limit to be allowed")
+		}
+	}
+
+	// 3/4 of the previous window still counts: 4 * 0.75 = 3
+	clk.Add(1250 * time.Millisecond)
+	ok1 := limiter.Allow()
+	ok2 := limiter.Allow()
+	if !ok1 || ok2 {
+		t.Error("Incorrect allowance behavior with a weighted previous window")
+	}
+
+	// two windows later, nothing from the past counts
+	clk.Add(2 * time.Second)
+	for i := 0; i < 4; i++ {
+		if !limiter.Allow() {
+			t.Fatal("Expected the limit to be available again")
+		}
+	}
+}
+
+func TestSlidingWindowLogReservation(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewSlidingWindowLog(3, 1*time.Second, clk)
+
+	if !limiter.AllowN(2) {
+		t.Error("Expected 2 requests to be allowed")
+	}
+	clk.Add(300 * time.Millisecond)
+	if limiter.AllowN(2) {
+		t.Error("Expected 2 more requests to be denied")
+	}
+	if limiter.Reserve() != 0 {
+		t.Error("Expected the last request to be reserved immediately")
+	}
+
+	// the first two requests leave the window 1s after they were made
+	if d := limiter.Reserve(); d != 700*time.Millisecond {
+		t.Errorf("Expected to wait 700ms, got %v", d)
+	}
+
+	clk.Add(700 * time.Millisecond)
+	if !limiter.NextAt().Equal(clk.Now()) || !limiter.Allow() {
+		t.Error("Expected a request to be allowed at the reported time")
+	}
+}
+
+func TestSlidingWindowCounterReservation(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewSlidingWindowCounter(4, 1*time.Second, clk)
+
+	if !limiter.AllowN(4) {
+		t.Fatal("Expected 4 requests to be allowed")
+	}
+
+	// in the next window, 4 * weight + 1 <= 4 once weight <= 3/4
+	clk.Add(1100 * time.Millisecond)
+	d := limiter.Reserve()
+	if d != 150*time.Millisecond {
+		t.Errorf("Expected to wait 150ms, got %v", d)
+	}
+
+	clk.Add(d)
+	if !limiter.NextAt().Equal(clk.Now()) || !limiter.Allow() {
+		t.Error("Expected a request to be allowed at the reported time")
+	}
+}
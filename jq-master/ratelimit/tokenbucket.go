@@ -1,49 +1,99 @@
 package ratelimit
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 // TokenBucket implements a token bucket rate limiter.
 type TokenBucket struct {
+	mutex      sync.Mutex
 	rate       float64 // Tokens added per second
 	capacity   int64   // Maximum tokens in the bucket
-	tokens     int64
+	tokens     float64
 	lastRefill time.Time
 
 	clock Clock
 }
 
 // NewTokenBucket creates a new token bucket with the given parameters.
+// A bucket whose rate is not positive never refills.
 func NewTokenBucket(rate float64, capacity int64, clock Clock) *TokenBucket {
 	return &TokenBucket{
 		rate:       rate,
 		capacity:   capacity,
-		tokens:     capacity, // Start with a full bucket
+		tokens:     float64(capacity), // Start with a full bucket
 		lastRefill: clock.Now(),
 		clock:      clock,
 	}
 }
 
+// refill adds tokens based on elapsed time, keeping fractions for low rates
+func (tb *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(tb.lastRefill); elapsed > 0 && tb.rate > 0 {
+		tb.tokens = min(tb.tokens+elapsed.Seconds()*tb.rate, float64(tb.capacity))
+		tb.lastRefill = now
+	}
+}
+
+// wait returns how long until n tokens are in the bucket.
+func (tb *TokenBucket) wait(n float64) time.Duration {
+	if tb.tokens >= n {
+		return 0
+	}
+	if tb.rate <= 0 {
+		return Never
+	}
+	return time.Duration((n - tb.tokens) / tb.rate * float64(time.Second))
+}
+
 // Allow checks if a request can be allowed, consuming a token if so.
 func (tb *TokenBucket) Allow() bool {
-	now := tb.clock.Now()
+	return tb.AllowN(1)
+}
 
-	// Refill tokens based on elapsed time
-	tokensToAdd := int64(now.Sub(tb.lastRefill).Seconds() * tb.rate)
-	if tokensToAdd > 0 {
-		tb.tokens = min(tb.tokens+tokensToAdd, tb.capacity)
-		tb.lastRefill = now
-	}
+// AllowN checks if n requests can be allowed at once, consuming n tokens if so.
+func (tb *TokenBucket) AllowN(n int64) bool {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
 
-	if tb.tokens > 0 {
-		tb.tokens--
+	tb.refill(tb.clock.Now())
+
+	if tb.tokens >= float64(n) {
+		tb.tokens -= float64(n)
 		return true
 	}
 
 	return false
 }
 
-// min returns the smaller of two int64 values
-func min(a, b int64) int64 {
+// Reserve consumes a token and returns 0 if one is available.
+// Otherwise it consumes nothing and returns how long until a token will be available.
+func (tb *TokenBucket) Reserve() time.Duration {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.refill(tb.clock.Now())
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0
+	}
+	return tb.wait(1)
+}
+
+// NextAt returns the earliest time a request would be allowed.
+func (tb *TokenBucket) NextAt() time.Time {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	now := tb.clock.Now()
+	tb.refill(now)
+	return now.Add(tb.wait(1))
+}
+
+// min returns the smaller of two float64 values
+func min(a, b float64) float64 {
 	if a < b {
 		return a
 	}
@@ -50,3 +50,73 @@ func TestRefilling(t *testing.T) {
 		t.Error("Incorrect allowance behavior after refill")
 	}
 }
+
+func TestFractionalRate(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewTokenBucket(0.3, 3, clk) // 0.3 tokens per second, capacity of 3
+
+	for i := 0; i < 3; i++ {
+		limiter.Allow()
+	}
+
+	// 0.9 tokens after 3 seconds
+	clk.Add(3 * time.Second)
+	if limiter.Allow() {
+		t.Error("Expected request to be denied before a whole token is refilled")
+	}
+
+	// 1.5 tokens after 5 seconds, 0.5 left after this request
+	clk.Add(2 * time.Second)
+	if !limiter.Allow() {
+		t.Error("Expected partial tokens to accumulate")
+	}
+
+	// 0.5 left over + 0.51 after 1.7 more seconds
+	clk.Add(1700 * time.Millisecond)
+	if !limiter.Allow() {
+		t.Error("Expected leftover fraction to be kept after a refill")
+	}
+}
+
+func TestTokenBucketReservation(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewTokenBucket(2, 4, clk) // 2 tokens per second, capacity of 4
+
+	if !limiter.AllowN(3) {
+		t.Error("Expected 3 tokens to be available")
+	}
+	if limiter.AllowN(2) {
+		t.Error("Expected 2 tokens not to be available")
+	}
+	if limiter.Reserve() != 0 {
+		t.Error("Expected the last token to be reserved immediately")
+	}
+
+	if d := limiter.Reserve(); d != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms for the next token, got %v", d)
+	}
+	if next := limiter.NextAt(); !next.Equal(clk.Now().Add(500 * time.Millisecond)) {
+		t.Errorf("Unexpected next token time: %v", next)
+	}
+
+	clk.Add(500 * time.Millisecond)
+	if !limiter.NextAt().Equal(clk.Now()) || !limiter.Allow() {
+		t.Error("Expected a token to be available at the reported time")
+	}
+}
+
+func TestTokenBucketWithoutRate(t *testing.T) {
+	clk := &TestableClock{now: time.Now()}
+	limiter := ratelimit.NewTokenBucket(0, 1, clk) // never refills
+
+	if !limiter.Allow() {
+		t.Error("Expected the initial token to be allowed")
+	}
+	clk.Add(time.Hour)
+	if wait := limiter.Reserve(); wait != ratelimit.Never {
+		t.Errorf("Expected to wait forever, got %v", wait)
+	}
+	if !limiter.NextAt().After(clk.Now().Add(100 * 365 * 24 * time.Hour)) {
+		t.Errorf("Expected no next request, got %v", limiter.NextAt())
+	}
+}
@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// BackoffConfig configures the exponential backoff applied between retries.
+type BackoffConfig struct {
+	BaseDelay  time.Duration // delay before the first retry
+	Multiplier float64       // factor applied to the delay for each following retry
+	MaxDelay   time.Duration // upper bound of the delay
+	Jitter     float64       // fraction (0 to 1) of the delay that is randomized away
+}
+
+var DefaultBackoffConfig = BackoffConfig{
+	BaseDelay:  1 * time.Second,
+	Multiplier: 2,
+	MaxDelay:   5 * time.Minute,
+	Jitter:     0.2,
+}
+
+// Delay returns how long to wait before the given retry (starting at 1).
+func (b BackoffConfig) Delay(retry int) time.Duration {
+	if retry < 1 {
+		retry = 1
+	}
+
+	delay := float64(b.BaseDelay) * math.Pow(b.Multiplier, float64(retry-1))
+	if b.MaxDelay > 0 && delay > float64(b.MaxDelay) {
+		delay = float64(b.MaxDelay)
+	}
+
+	if b.Jitter > 0 {
+		jitter := math.Min(b.Jitter, 1)
+		delay -= delay * jitter * rand.Float64()
+	}
+
+	// without a MaxDelay the exponential term can grow past what a
+	// Duration can hold (even to +Inf), so clamp before converting
+	if math.IsNaN(delay) {
+		return 0
+	}
+	if delay >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,52 @@
+package scheduler_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+)
+
+func TestBackoffExponential(t *testing.T) {
+	b := scheduler.BackoffConfig{
+		BaseDelay:  1 * time.Second,
+		Multiplier: 2,
+		MaxDelay:   10 * time.Second,
+	}
+
+	expected := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, want := range expected {
+		if got := b.Delay(i + 1); got != want {
+			t.Errorf("retry %d: expected %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := scheduler.BackoffConfig{
+		BaseDelay:  4 * time.Second,
+		Multiplier: 2,
+		Jitter:     0.5,
+	}
+
+	for i := 0; i < 100; i++ {
+		got := b.Delay(1)
+		if got < 2*time.Second || got > 4*time.Second {
+			t.Fatalf("jittered delay out of range: %v", got)
+		}
+	}
+}
+
+func TestBackoffWithoutMaxDelay(t *testing.T) {
+	b := scheduler.BackoffConfig{
+		BaseDelay:  1 * time.Second,
+		Multiplier: 2,
+	}
+
+	for _, retry := range []int{64, 1100, 100000} {
+		if got := b.Delay(retry); got != time.Duration(math.MaxInt64) {
+			t.Errorf("retry %d: expected the delay to be clamped, got %v", retry, got)
+		}
+	}
+}
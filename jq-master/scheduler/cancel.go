@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RCancelledJobs       = "jq:cancelledJobs"       // job id -> time the job was cancelled (unix millis)
+	RCancelRequestedJobs = "jq:cancelRequestedJobs" // running jobs whose worker was asked to cancel them
+)
+
+var ErrJobNotCancellable = errors.New("job is in a non-cancellable state")
+
+// removes a job that has not started yet from wherever it waits
+// KEYS[1]: scored, KEYS[2]: delayed, KEYS[3]: scheduled, KEYS[4]: waiting,
+// KEYS[5]: global queue, KEYS[6]: processing jobs, KEYS[7]: deadlines, KEYS[8]: cancel requested,
+// KEYS[9]: parked jobs
+// ARGV[1]: job id
+// returns 1 if the job was removed, 2 if it is running, 0 otherwise
+var cancelJobScript = redis.NewScript(`
+local removed = redis.call("ZREM", KEYS[1], ARGV[1]) + redis.call("ZREM", KEYS[2], ARGV[1])
+	+ redis.call("ZREM", KEYS[3], ARGV[1]) + redis.call("SREM", KEYS[4], ARGV[1])
+	+ redis.call("SREM", KEYS[9], ARGV[1])
+if removed > 0 then
+	return 1
+end
+-- dispatched but not yet picked up by a worker
+if redis.call("LREM", KEYS[5], 0, ARGV[1]) > 0 then
+	redis.call("SREM", KEYS[6], ARGV[1])
+	redis.call("ZREM", KEYS[7], ARGV[1])
+	return 1
+end
+if redis.call("SISMEMBER", KEYS[6], ARGV[1]) == 1 then
+	redis.call("SADD", KEYS[8], ARGV[1])
+	return 2
+end
+return 0
+`)
+
+// CancelJob cancels a job. A job that has not started yet is removed right away;
+// for a running job its worker is asked to stop (over HTTP for a push worker), and the job is recorded as cancelled
+// once the worker reports back. Jobs that already finished cannot be cancelled.
+func (s *Scheduler) CancelJob(ctx context.Context, jobID string) error {
+	res, err := cancelJobScript.Run(ctx, s.r, []string{
+		RScoredJobSet, RDelayedJobSet, RScheduledJobSet, RWaitingJobs,
+		transport.RGlobalQueue, RProcessingJobs, RJobDeadlines, RCancelRequestedJobs,
+		RParkedJobs,
+	}, jobID).Int()
+	if err != nil {
+		return err
+	}
+
+	switch res {
+	case 1:
+		// it may have held constraint capacity if it was already dispatched
+		if err := s.releaseConstraints(ctx, jobID, s.getConstraints()); err != nil {
+			log.Printf("error releasing constraints of job %s: %v", jobID, err)
+		}
+		log.Printf("job %s cancelled", jobID)
+		return s.finishCancelledJob(ctx, jobID, cancelledResult(jobID, time.Now()))
+	case 2:
+		log.Printf("job %s is running; asking its worker to cancel it", jobID)
+		return s.requestCancel(ctx, jobID)
+	}
+
+	exists, err := s.r.Exists(ctx, makeJobKey(jobID), makeDeadJobKey(jobID), makeJobStateKey(jobID)).Result()
+	if err != nil {
+		return err
+	}
+	cancelled, err := s.r.ZScore(ctx, RCancelledJobs, jobID).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if exists > 0 || cancelled > 0 {
+		return ErrJobNotCancellable
+	}
+	return ErrJobNotFound
+}
+
+// requestCancel asks the worker running the job to stop it: a push worker over HTTP,
+// pulling workers through redis.
+func (s *Scheduler) requestCancel(ctx context.Context, jobID string) error {
+	owner, err := s.getJobOwner(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if owner != "" {
+		// workers are read from redis, as any master may serve the request
+		w, err := s.loadWorker(ctx, owner)
+		if err != nil {
+			return err
+		}
+		if w != nil && w.IsPush() {
+			return transport.CancelPushedJob(ctx, s.httpClient, w.URL, jobID)
+		}
+	}
+	return s.tran.PublishCancel(ctx, jobID)
+}
+
+// takeCancelRequest reports whether the job was asked to be cancelled while running.
+func (s *Scheduler) takeCancelRequest(ctx context.Context, jobID string) (bool, error) {
+	n, err := s.r.SRem(ctx, RCancelRequestedJobs, jobID).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// finishCancelledJob records the job as cancelled, drops its data and reports the cancellation to the pusher.
+func (s *Scheduler) finishCancelledJob(ctx context.Context, jobID string, result transport.JobResult) error {
+	job, err := s.getJob(ctx, jobID)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	job.ID = jobID
+
+	result.Reason = transport.JobFailureReasonCancelled
+	fields, err := resultStateFields(result)
+	if err != nil {
+		return err
+	}
+
+	tx := s.r.TxPipeline()
+	s.recordJobState(ctx, tx, jobID, JobStatusCancelled, fields...)
+	tx.Del(ctx, makeJobKey(jobID), makeJobParentsKey(jobID), makeJobParentResultsKey(jobID))
+	tx.ZAdd(ctx, RCancelledJobs, redis.Z{
+		Score:  float64(time.Now().UnixMilli()),
+		Member: jobID,
+	})
+	if _, err := tx.Exec(ctx); err != nil {
+		return err
+	}
+
+	if err := s.releaseUniqueKey(ctx, job); err != nil {
+		log.Printf("error releasing unique key of job %s: %v", jobID, err)
+	}
+	// jobs waiting for this one can never run
+	if err := s.failChildren(ctx, jobID); err != nil {
+		log.Printf("error failing children of job %s: %v", jobID, err)
+	}
+
+	return s.publishFinalResult(ctx, job, result)
+}
+
+func cancelledResult(jobID string, now time.Time) transport.JobResult {
+	return transport.JobResult{
+		JobID:      jobID,
+		Type:       transport.JobResultFailure,
+		FinishedAt: now.Format(time.RFC3339),
+		Reason:     transport.JobFailureReasonCancelled,
+		Message:    "job cancelled",
+	}
+}
@@ -0,0 +1,107 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCancelQueuedJob(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	job := scheduler.Job{ID: "cancel-queued-job", Name: "job"}
+	cleanupJob(t, r, job.ID)
+	if _, err := s.AddJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CancelJob(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ZScore(ctx, scheduler.RScoredJobSet, job.ID).Result(); err != redis.Nil {
+		t.Errorf("expected the job to be removed from the queue (%v)", err)
+	}
+	state, err := s.GetJobState(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != scheduler.JobStatusCancelled {
+		t.Errorf("expected the job to be cancelled, got %s", state.Status)
+	}
+
+	if err := s.CancelJob(ctx, job.ID); !errors.Is(err, scheduler.ErrJobNotCancellable) {
+		t.Errorf("cancelling twice: expected ErrJobNotCancellable, got %v", err)
+	}
+	if err := s.CancelJob(ctx, "cancel-unknown-job"); !errors.Is(err, scheduler.ErrJobNotFound) {
+		t.Errorf("unknown job: expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestCancelRunningPushedJob(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	cancelled := make(chan string, 1)
+	addPushWorker(t, s, "cancel-push-worker", 1, func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodDelete:
+			cancelled <- req.URL.Path
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	job := scheduler.Job{ID: "cancel-pushed-job", Name: "job"}
+	cleanupJob(t, r, job.ID)
+	if _, err := s.AddJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	go s.DistributeJobs(ctx, make(chan transport.JobResult))
+
+	// the owner is recorded once the worker accepted the job
+	for r.HGet(ctx, scheduler.RJobOwners, job.ID).Val() != "cancel-push-worker" {
+		if ctx.Err() != nil {
+			t.Fatal("the job was never pushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.CancelJob(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case path := <-cancelled:
+		if path != "/worker-job/"+job.ID {
+			t.Errorf("unexpected cancel request for %s", path)
+		}
+	case <-ctx.Done():
+		t.Fatal("the worker was never asked to cancel the job")
+	}
+
+	// the worker reports the job as stopped
+	result := transport.JobResult{
+		JobID: job.ID, Type: transport.JobResultFailure, Reason: transport.JobFailureReasonCancelled, WorkerID: "cancel-push-worker",
+	}
+	if err := s.ProcessResult(ctx, result); err != nil {
+		t.Fatal(err)
+	}
+	state, err := s.GetJobState(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != scheduler.JobStatusCancelled {
+		t.Errorf("expected the job to be cancelled, got %s", state.Status)
+	}
+}
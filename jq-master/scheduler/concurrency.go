@@ -0,0 +1,231 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// how long a slot may be held before its job shows up in jq:processingJobs
+const concurrencySlotGrace = 10 * time.Second
+
+// KEYS[1]: slots of the group, KEYS[2]: processing jobs, KEYS[3]: group held by the job
+// ARGV[1]: job id, ARGV[2]: limit, ARGV[3]: now (unix millis), ARGV[4]: grace (millis), ARGV[5]: group
+var acquireSlotScript = redis.NewScript(`
+local now = tonumber(ARGV[3])
+local slots = redis.call("ZRANGE", KEYS[1], 0, -1, "WITHSCORES")
+for i = 1, #slots, 2 do
+	local jobID = slots[i]
+	if tonumber(slots[i + 1]) < now - tonumber(ARGV[4]) and redis.call("SISMEMBER", KEYS[2], jobID) == 0 then
+		-- the job is no longer running; its slot was leaked
+		redis.call("ZREM", KEYS[1], jobID)
+	end
+end
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+	return 1
+end
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now, ARGV[1])
+redis.call("SET", KEYS[3], ARGV[5])
+return 1
+`)
+
+// RParkedJobs holds the queued jobs a concurrency limit took out of the scored job set
+// because all slots of their group were taken.
+const RParkedJobs = "jq:parkedJobs"
+
+// wakeParkedLua defines wake, which moves up to n jobs parked for a group back to the scored job set.
+// Jobs that left the parked jobs in the meantime (e.g. cancelled ones) are skipped.
+const wakeParkedLua = `
+local function wake(scored, parkedJobs, parkedGroups, parked, group, n)
+	while n > 0 do
+		local popped = redis.call("ZPOPMIN", parked)
+		if #popped == 0 then
+			break
+		end
+		if redis.call("SREM", parkedJobs, popped[1]) == 1 then
+			redis.call("ZADD", scored, popped[2], popped[1])
+			n = n - 1
+		end
+	end
+	if redis.call("EXISTS", parked) == 0 then
+		redis.call("SREM", parkedGroups, group)
+	end
+end
+`
+
+// KEYS[1]: group held by the job, KEYS[2]: scored, KEYS[3]: parked jobs, KEYS[4]: parked groups,
+// KEYS[5]: slots of the group, KEYS[6]: parked for the group
+// ARGV[1]: job id, ARGV[2]: group, ARGV[3]: limit
+// returns 0 if the job no longer holds a slot of the group, e.g. because it was released in the meantime
+var releaseSlotScript = redis.NewScript(wakeParkedLua + `
+if redis.call("GET", KEYS[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("ZREM", KEYS[5], ARGV[1])
+redis.call("DEL", KEYS[1])
+-- let the jobs waiting for the freed slot compete for it again
+local free = tonumber(ARGV[3]) - redis.call("ZCARD", KEYS[5])
+wake(KEYS[2], KEYS[3], KEYS[4], KEYS[6], ARGV[2], free)
+return 1
+`)
+
+// KEYS[1]: scored, KEYS[2]: parked jobs, KEYS[3]: parked groups, KEYS[4]: slots of the group, KEYS[5]: parked for the group
+// ARGV[1]: job id, ARGV[2]: group, ARGV[3]: limit
+// returns 1 if the job was parked
+var parkScript = redis.NewScript(`
+if redis.call("ZCARD", KEYS[4]) < tonumber(ARGV[3]) then
+	-- a slot was freed in the meantime
+	return 0
+end
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score then
+	return 0
+end
+redis.call("ZREM", KEYS[1], ARGV[1])
+redis.call("SADD", KEYS[2], ARGV[1])
+redis.call("ZADD", KEYS[5], score, ARGV[1])
+redis.call("SADD", KEYS[3], ARGV[2])
+return 1
+`)
+
+// wakes the jobs of a group whose slots were freed without a release, i.e. leaked slots
+// KEYS[1]: scored, KEYS[2]: parked jobs, KEYS[3]: parked groups, KEYS[4]: processing jobs,
+// KEYS[5]: slots of the group, KEYS[6]: parked for the group
+// ARGV[1]: group, ARGV[2]: limit, ARGV[3]: now (unix millis), ARGV[4]: grace (millis)
+var wakeLeakedScript = redis.NewScript(wakeParkedLua + `
+local now = tonumber(ARGV[3])
+local slots = redis.call("ZRANGE", KEYS[5], 0, -1, "WITHSCORES")
+for i = 1, #slots, 2 do
+	if tonumber(slots[i + 1]) < now - tonumber(ARGV[4]) and redis.call("SISMEMBER", KEYS[4], slots[i]) == 0 then
+		redis.call("ZREM", KEYS[5], slots[i])
+	end
+end
+local free = tonumber(ARGV[2]) - redis.call("ZCARD", KEYS[5])
+wake(KEYS[1], KEYS[2], KEYS[3], KEYS[6], ARGV[1], free)
+return 0
+`)
+
+// ConcurrencyConstraint caps how many jobs matched by its matcher run at the same time.
+// Jobs are counted separately for each combination of the groupBy argument fields.
+type ConcurrencyConstraint struct {
+	r       *redis.Client
+	name    string
+	matcher JobMatcher
+	limit   int
+	groupBy []string
+}
+
+func (c *ConcurrencyConstraint) group(job Job) string {
+	group := make([]string, len(c.groupBy))
+	for i, field := range c.groupBy {
+		if v, ok := lookupArgument(job.Argument, field); ok {
+			group[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(group, ",")
+}
+
+// slotsKey holds the jobs running in the group by when they took their slot.
+func (c *ConcurrencyConstraint) slotsKey(group string) string {
+	return "jq:concurrency:" + c.name + ":" + group
+}
+
+// parkedKey holds the parked jobs of the group by priority score.
+func (c *ConcurrencyConstraint) parkedKey(group string) string {
+	return "jq:concurrencyParked:" + c.name + ":" + group
+}
+
+// parkedGroupsKey holds the groups that have parked jobs.
+func (c *ConcurrencyConstraint) parkedGroupsKey() string {
+	return "jq:concurrencyParkedGroups:" + c.name
+}
+
+// heldKey holds the group whose slot the job holds.
+func (c *ConcurrencyConstraint) heldKey(jobID string) string {
+	return "jq:concurrencyHeld:" + c.name + ":" + jobID
+}
+
+// Acquire takes a slot for the job. When none is free, it cannot tell when one will be.
+func (c *ConcurrencyConstraint) Acquire(ctx context.Context, job Job) (bool, time.Time, error) {
+	if !c.matcher.Match(job) {
+		return true, time.Time{}, nil
+	}
+
+	group := c.group(job)
+	n, err := acquireSlotScript.Run(ctx, c.r,
+		[]string{c.slotsKey(group), RProcessingJobs, c.heldKey(job.ID)},
+		job.ID, c.limit, time.Now().UnixMilli(), concurrencySlotGrace.Milliseconds(), group,
+	).Int()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	return n == 1, time.Time{}, nil
+}
+
+// Release frees the slot of the job and puts jobs parked for it back in the queue.
+func (c *ConcurrencyConstraint) Release(ctx context.Context, jobID string) error {
+	group, err := c.r.Get(ctx, c.heldKey(jobID)).Result()
+	if err == redis.Nil {
+		// the job holds no slot
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return releaseSlotScript.Run(ctx, c.r,
+		[]string{c.heldKey(jobID), RScoredJobSet, RParkedJobs, c.parkedGroupsKey(), c.slotsKey(group), c.parkedKey(group)},
+		jobID, group, c.limit,
+	).Err()
+}
+
+// park takes the queued job out of the scored job set until a slot of its group is released,
+// so that it does not have to be looked at again on every scan.
+// It reports false if the job was not parked, e.g. because a slot was freed in the meantime.
+func (c *ConcurrencyConstraint) park(ctx context.Context, job Job) (bool, error) {
+	group := c.group(job)
+	n, err := parkScript.Run(ctx, c.r,
+		[]string{RScoredJobSet, RParkedJobs, c.parkedGroupsKey(), c.slotsKey(group), c.parkedKey(group)},
+		job.ID, group, c.limit,
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// wakeLeaked puts back parked jobs of groups whose slots leaked, since no release will wake them.
+func (c *ConcurrencyConstraint) wakeLeaked(ctx context.Context) error {
+	groups, err := c.r.SMembers(ctx, c.parkedGroupsKey()).Result()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		err := wakeLeakedScript.Run(ctx, c.r,
+			[]string{RScoredJobSet, RParkedJobs, c.parkedGroupsKey(), RProcessingJobs, c.slotsKey(group), c.parkedKey(group)},
+			group, c.limit, time.Now().UnixMilli(), concurrencySlotGrace.Milliseconds(),
+		).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddConcurrencyLimit allows at most limit jobs matched by matcher to run at once.
+// With groupBy argument fields (e.g. "domain"), the limit applies to each distinct combination of their values.
+// name identifies the limit and must be unique.
+func (s *Scheduler) AddConcurrencyLimit(name string, matcher JobMatcher, limit int, groupBy ...string) {
+	s.AddConstraint(&ConcurrencyConstraint{
+		r:       s.r,
+		name:    name,
+		matcher: matcher,
+		limit:   limit,
+		groupBy: groupBy,
+	})
+}
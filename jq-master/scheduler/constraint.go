@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/ratelimit"
+)
+
+// Constraint restricts when matching jobs may be dispatched.
+type Constraint interface {
+	// Acquire reports whether the job may be dispatched now, consuming capacity if so.
+	// When it may not, retryAt tells when it is worth trying again (zero if unknown).
+	Acquire(ctx context.Context, job Job) (ok bool, retryAt time.Time, err error)
+	// Release gives back capacity held by the job, if any.
+	Release(ctx context.Context, jobID string) error
+}
+
+// parkingConstraint is implemented by constraints that can hold back the jobs they deny
+// until they free capacity themselves, instead of having them looked at on every scan.
+type parkingConstraint interface {
+	Constraint
+	park(ctx context.Context, job Job) (bool, error)
+	// wakeLeaked puts back parked jobs whose capacity was freed without a release.
+	wakeLeaked(ctx context.Context) error
+}
+
+// RateLimitConstraint limits how often jobs matched by its matcher are dispatched.
+type RateLimitConstraint struct {
+	name     string
+	matcher  JobMatcher
+	limiters *ratelimit.RateLimiterCollection
+}
+
+func (c *RateLimitConstraint) Acquire(ctx context.Context, job Job) (bool, time.Time, error) {
+	if !c.matcher.Match(job) {
+		return true, time.Time{}, nil
+	}
+
+	if wait := c.limiters.Reserve(c.name); wait > 0 {
+		return false, time.Now().Add(wait), nil
+	}
+	return true, time.Time{}, nil
+}
+
+// nextAt returns when the job would be allowed without consuming anything; the zero time if it is not limited.
+func (c *RateLimitConstraint) nextAt(job Job) time.Time {
+	if !c.matcher.Match(job) {
+		return time.Time{}
+	}
+	return c.limiters.NextAt(c.name)
+}
+
+// Release does nothing; dispatch rate is not given back when a job finishes.
+func (c *RateLimitConstraint) Release(ctx context.Context, jobID string) error {
+	return nil
+}
+
+// AddConstraint registers a constraint consulted before dispatching each job.
+func (s *Scheduler) AddConstraint(c Constraint) {
+	s.constraintsMutex.Lock()
+	defer s.constraintsMutex.Unlock()
+
+	s.constraints = append(s.constraints, c)
+}
+
+// AddRateLimit limits the dispatch rate of jobs matched by matcher.
+// name identifies the limit and must be unique.
+func (s *Scheduler) AddRateLimit(name string, matcher JobMatcher, limiter ratelimit.RateLimiter) {
+	s.limiters.AddRateLimitter(name, limiter)
+	s.AddConstraint(&RateLimitConstraint{
+		name:     name,
+		matcher:  matcher,
+		limiters: s.limiters,
+	})
+}
+
+func (s *Scheduler) getConstraints() []Constraint {
+	s.constraintsMutex.Lock()
+	defer s.constraintsMutex.Unlock()
+
+	return s.constraints
+}
+
+// acquireConstraints reports whether every constraint allows the job to be dispatched now.
+// If not, denied is the constraint that denied it and retryAt the time it suggested (zero if unknown).
+// Capacity held by a constraint can be given back, but a consumed rate limit token cannot,
+// so rate limits are only peeked at first and consumed after every other constraint allowed the job.
+func (s *Scheduler) acquireConstraints(ctx context.Context, job Job, constraints []Constraint) (ok bool, retryAt time.Time, denied Constraint, err error) {
+	var rateLimits []Constraint
+	for _, c := range constraints {
+		if rl, ok := c.(*RateLimitConstraint); ok {
+			if nextAt := rl.nextAt(job); nextAt.After(time.Now()) {
+				return false, nextAt, c, nil
+			}
+			rateLimits = append(rateLimits, c)
+		}
+	}
+
+	for _, c := range constraints {
+		if _, ok := c.(*RateLimitConstraint); ok {
+			continue
+		}
+		if ok, retryAt, err := s.acquireConstraint(ctx, job, c, constraints); !ok {
+			return false, retryAt, c, err
+		}
+	}
+	for _, c := range rateLimits {
+		if ok, retryAt, err := s.acquireConstraint(ctx, job, c, constraints); !ok {
+			return false, retryAt, c, err
+		}
+	}
+	return true, time.Time{}, nil, nil
+}
+
+// acquireConstraint acquires a single constraint, giving back everything the job holds if it is denied.
+func (s *Scheduler) acquireConstraint(ctx context.Context, job Job, c Constraint, constraints []Constraint) (bool, time.Time, error) {
+	ok, retryAt, err := c.Acquire(ctx, job)
+	if err == nil && ok {
+		return true, time.Time{}, nil
+	}
+
+	// give back what the preceding constraints handed out
+	if releaseErr := s.releaseConstraints(ctx, job.ID, constraints); releaseErr != nil {
+		log.Printf("error releasing constraints of job %s: %v", job.ID, releaseErr)
+	}
+	return false, retryAt, err
+}
+
+// wakeLeakedJobs puts back the jobs parked by constraints whose capacity leaked.
+func (s *Scheduler) wakeLeakedJobs(ctx context.Context) error {
+	for _, c := range s.getConstraints() {
+		if pc, ok := c.(parkingConstraint); ok {
+			if err := pc.wakeLeaked(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseConstraints gives back capacity held by the job in any of the constraints.
+func (s *Scheduler) releaseConstraints(ctx context.Context, jobID string, constraints []Constraint) error {
+	for _, c := range constraints {
+		if err := c.Release(ctx, jobID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/ratelimit"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+// cleanupConcurrency removes the slots of a concurrency limit once the test ends.
+func cleanupConcurrency(t *testing.T, r *redis.Client, name string) {
+	t.Helper()
+	t.Cleanup(func() {
+		ctx := context.Background()
+		keys, _ := r.Keys(ctx, "jq:concurrency*:"+name+":*").Result()
+		if len(keys) > 0 {
+			r.Del(ctx, keys...)
+		}
+	})
+}
+
+// popJob waits for the next job allowed by the constraints, giving up after wait.
+func popJob(s *scheduler.Scheduler, wait time.Duration) (scheduler.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	return s.BlockJobPop(ctx)
+}
+
+// finishJob reports the popped job as done, as if a worker ran it.
+func finishJob(t *testing.T, r *redis.Client, s *scheduler.Scheduler, jobID string) {
+	t.Helper()
+	ctx := context.Background()
+	r.SAdd(ctx, scheduler.RProcessingJobs, jobID)
+	if err := s.ProcessResult(ctx, transport.JobResult{JobID: jobID, Type: transport.JobResultSuccess}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrencyLimitGatesJobs(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	name := "gating-" + t.Name()
+	cleanupConcurrency(t, r, name)
+	// the concurrency limit denying the second job must not use up the tokens it needs later
+	s.AddRateLimit(name, scheduler.JobNameMatcher("gated"), ratelimit.NewTokenBucket(0.001, 2, ratelimit.DefaultClock))
+	s.AddConcurrencyLimit(name, scheduler.JobNameMatcher("gated"), 1)
+
+	first := scheduler.Job{ID: "gating-first", Name: "gated", Priority: 1}
+	second := scheduler.Job{ID: "gating-second", Name: "gated", Priority: 2}
+	for _, job := range []scheduler.Job{first, second} {
+		cleanupJob(t, r, job.ID)
+		if _, err := s.AddJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := popJob(s, time.Second)
+	if err != nil || job.ID != first.ID {
+		t.Fatalf("expected the first job, got %q (%v)", job.ID, err)
+	}
+	// several scans find the second job blocked by the running one
+	if job, err := popJob(s, time.Second); err == nil {
+		t.Fatalf("expected the second job to wait, got %q", job.ID)
+	}
+
+	finishJob(t, r, s, first.ID)
+	job, err = popJob(s, 2*time.Second)
+	if err != nil || job.ID != second.ID {
+		t.Fatalf("expected the second job once the first finished, got %q (%v)", job.ID, err)
+	}
+	finishJob(t, r, s, second.ID)
+}
+
+func TestRateLimitParksJobs(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+	s.AddRateLimit("parking-"+t.Name(), scheduler.JobNameMatcher("limited"), ratelimit.NewTokenBucket(0.001, 1, ratelimit.DefaultClock))
+
+	first := scheduler.Job{ID: "ratelimit-first", Name: "limited", Priority: 1}
+	second := scheduler.Job{ID: "ratelimit-second", Name: "limited", Priority: 2}
+	for _, job := range []scheduler.Job{first, second} {
+		cleanupJob(t, r, job.ID)
+		if _, err := s.AddJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := popJob(s, time.Second)
+	if err != nil || job.ID != first.ID {
+		t.Fatalf("expected the first job, got %q (%v)", job.ID, err)
+	}
+	if job, err := popJob(s, 500*time.Millisecond); err == nil {
+		t.Fatalf("expected the second job to be rate limited, got %q", job.ID)
+	}
+
+	readyAt, err := r.ZScore(ctx, scheduler.RDelayedJobSet, second.ID).Result()
+	if err != nil {
+		t.Fatalf("expected the second job to be parked: %v", err)
+	}
+	if wait := time.UnixMilli(int64(readyAt)).Sub(time.Now()); wait < 10*time.Minute {
+		t.Errorf("expected the job to be parked until a token is available, got %v", wait)
+	}
+	finishJob(t, r, s, first.ID)
+}
+
+func TestConcurrencyLimitParksBlockedJobs(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	name := "parking-" + t.Name()
+	cleanupConcurrency(t, r, name)
+	s.AddConcurrencyLimit(name, scheduler.JobNameMatcher("gated"), 1)
+
+	running := scheduler.Job{ID: "parking-running", Name: "gated", Priority: 1}
+	blocked := scheduler.Job{ID: "parking-blocked", Name: "gated", Priority: 2}
+	cancelled := scheduler.Job{ID: "parking-cancelled", Name: "gated", Priority: 3}
+	free := scheduler.Job{ID: "parking-free", Name: "free", Priority: 4}
+	for _, job := range []scheduler.Job{running, blocked, cancelled, free} {
+		cleanupJob(t, r, job.ID)
+		r.SRem(ctx, scheduler.RParkedJobs, job.ID)
+		if _, err := s.AddJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if job, err := popJob(s, time.Second); err != nil || job.ID != running.ID {
+		t.Fatalf("expected %s, got %q (%v)", running.ID, job.ID, err)
+	}
+	// the blocked jobs are parked instead of being looked at on every scan
+	if job, err := popJob(s, time.Second); err != nil || job.ID != free.ID {
+		t.Fatalf("expected %s, got %q (%v)", free.ID, job.ID, err)
+	}
+	for _, jobID := range []string{blocked.ID, cancelled.ID} {
+		if !r.SIsMember(ctx, scheduler.RParkedJobs, jobID).Val() {
+			t.Errorf("expected %s to be parked", jobID)
+		}
+		if err := r.ZScore(ctx, scheduler.RScoredJobSet, jobID).Err(); err != redis.Nil {
+			t.Errorf("expected %s to leave the queue, got %v", jobID, err)
+		}
+	}
+
+	// a parked job can still be cancelled
+	if err := s.CancelJob(ctx, cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the freed slot wakes the parked job that is still wanted
+	finishJob(t, r, s, running.ID)
+	if job, err := popJob(s, time.Second); err != nil || job.ID != blocked.ID {
+		t.Fatalf("expected %s, got %q (%v)", blocked.ID, job.ID, err)
+	}
+	if job, err := popJob(s, 500*time.Millisecond); err == nil {
+		t.Errorf("expected no more jobs, got %q", job.ID)
+	}
+	finishJob(t, r, s, blocked.ID)
+	finishJob(t, r, s, free.ID)
+}
+
+func TestConcurrencyGroupLikeHeldSlot(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	name := "held-" + t.Name()
+	cleanupConcurrency(t, r, name)
+	s.AddConcurrencyLimit(name, scheduler.JobNameMatcher("grouped"), 1, "domain")
+
+	first := scheduler.Job{ID: "held-first", Name: "grouped", Priority: 1, Argument: map[string]interface{}{"domain": "example.com"}}
+	// the group of the second job reads like the slot held by the first one
+	second := scheduler.Job{ID: "held-second", Name: "grouped", Priority: 2, Argument: map[string]interface{}{"domain": "job:" + first.ID}}
+	for _, job := range []scheduler.Job{first, second} {
+		cleanupJob(t, r, job.ID)
+		if _, err := s.AddJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []string{first.ID, second.ID} {
+		job, err := popJob(s, time.Second)
+		if err != nil || job.ID != want {
+			t.Fatalf("expected %q, got %q (%v)", want, job.ID, err)
+		}
+	}
+	finishJob(t, r, s, first.ID)
+	finishJob(t, r, s, second.ID)
+}
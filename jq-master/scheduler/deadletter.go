@@ -0,0 +1,190 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	RDeadJobSet = "jq:deadJobSet" // job id -> time the job was dead-lettered (unix millis)
+)
+
+var ErrDeadJobNotFound = errors.New("dead job not found")
+
+func makeDeadJobKey(jobID string) string {
+	return "jq:deadJob:" + jobID
+}
+
+// DeadJob is a job that exhausted its retries, kept together with its final result.
+type DeadJob struct {
+	Job    Job                 `msgpack:"job"`
+	Result transport.JobResult `msgpack:"result"`
+	DiedAt time.Time           `msgpack:"died_at"`
+}
+
+// addDeadJob moves the job data into the dead-letter store.
+func (s *Scheduler) addDeadJob(ctx context.Context, job Job, result transport.JobResult) error {
+	dead := DeadJob{
+		Job:    job,
+		Result: result,
+		DiedAt: time.Now(),
+	}
+	deadBin, err := msgpack.Marshal(&dead)
+	if err != nil {
+		return err
+	}
+
+	fields, err := resultStateFields(result)
+	if err != nil {
+		return err
+	}
+
+	tx := s.r.TxPipeline()
+	s.recordJobState(ctx, tx, job.ID, JobStatusError, fields...)
+	tx.Set(ctx, makeDeadJobKey(job.ID), deadBin, 0)
+	tx.ZAdd(ctx, RDeadJobSet, redis.Z{
+		Score:  float64(dead.DiedAt.UnixMilli()),
+		Member: job.ID,
+	})
+	tx.Del(ctx, makeJobKey(job.ID))
+	_, err = tx.Exec(ctx)
+	return err
+}
+
+// ListDeadJobs returns dead jobs, most recently dead-lettered first.
+func (s *Scheduler) ListDeadJobs(ctx context.Context, offset, limit int64) ([]DeadJob, error) {
+	jobIDs, err := s.r.ZRevRange(ctx, RDeadJobSet, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(jobIDs) == 0 {
+		return []DeadJob{}, nil
+	}
+
+	keys := make([]string, len(jobIDs))
+	for i, jobID := range jobIDs {
+		keys[i] = makeDeadJobKey(jobID)
+	}
+	deadBins, err := s.r.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deadJobs := make([]DeadJob, 0, len(deadBins))
+	for _, deadBin := range deadBins {
+		deadStr, ok := deadBin.(string)
+		if !ok {
+			// purged in the meantime
+			continue
+		}
+		var dead DeadJob
+		if err := msgpack.Unmarshal([]byte(deadStr), &dead); err != nil {
+			return nil, err
+		}
+		deadJobs = append(deadJobs, dead)
+	}
+
+	return deadJobs, nil
+}
+
+// GetDeadJob returns a single dead job.
+func (s *Scheduler) GetDeadJob(ctx context.Context, jobID string) (DeadJob, error) {
+	deadBin, err := s.r.Get(ctx, makeDeadJobKey(jobID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return DeadJob{}, ErrDeadJobNotFound
+	}
+	if err != nil {
+		return DeadJob{}, err
+	}
+
+	var dead DeadJob
+	if err := msgpack.Unmarshal(deadBin, &dead); err != nil {
+		return DeadJob{}, err
+	}
+	return dead, nil
+}
+
+// RequeueDeadJob enqueues a dead job again with its retry count reset.
+// A job whose unique key was taken by another job in the meantime stays dead and ErrDuplicateJob is returned.
+func (s *Scheduler) RequeueDeadJob(ctx context.Context, jobID string) error {
+	dead, err := s.GetDeadJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+
+	// claim the dead job so that it is requeued only once
+	n, err := s.r.ZRem(ctx, RDeadJobSet, jobID).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDeadJobNotFound
+	}
+
+	// parents are not waited for again
+	job := dead.Job
+	job.CurrentRetry = 0
+	if job.UniqueKey != "" {
+		holderID, err := s.claimUniqueKey(ctx, job)
+		if err == nil && holderID != jobID {
+			err = fmt.Errorf("%w: %s", ErrDuplicateJob, holderID)
+		}
+		if err != nil {
+			// leave the job dead-lettered
+			if restoreErr := s.r.ZAdd(ctx, RDeadJobSet, redis.Z{Score: float64(dead.DiedAt.UnixMilli()), Member: jobID}).Err(); restoreErr != nil {
+				log.Printf("error restoring dead job %s: %v", jobID, restoreErr)
+			}
+			return err
+		}
+	}
+	if err := s.forceJobState(ctx, s.r, jobID, JobStatusQueued).Err(); err != nil {
+		return err
+	}
+	if err := s.enqueueJob(ctx, job); err != nil {
+		return err
+	}
+
+	return s.r.Del(ctx, makeDeadJobKey(jobID)).Err()
+}
+
+// PurgeDeadJob removes a dead job permanently.
+func (s *Scheduler) PurgeDeadJob(ctx context.Context, jobID string) error {
+	tx := s.r.TxPipeline()
+	zrem := tx.ZRem(ctx, RDeadJobSet, jobID)
+	tx.Del(ctx, makeDeadJobKey(jobID), makeJobStateKey(jobID))
+	if _, err := tx.Exec(ctx); err != nil {
+		return err
+	}
+	if zrem.Val() == 0 {
+		return ErrDeadJobNotFound
+	}
+	return nil
+}
+
+// PurgeDeadJobs removes every dead job and returns how many were removed.
+func (s *Scheduler) PurgeDeadJobs(ctx context.Context) (int, error) {
+	jobIDs, err := s.r.ZRange(ctx, RDeadJobSet, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, jobID := range jobIDs {
+		err := s.PurgeDeadJob(ctx, jobID)
+		if errors.Is(err, ErrDeadJobNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
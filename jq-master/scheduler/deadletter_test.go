@@ -0,0 +1,136 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+// deadLetter runs the job once and fails it for good.
+func deadLetter(t *testing.T, r *redis.Client, s *scheduler.Scheduler, jobID string) {
+	t.Helper()
+	result := transport.JobResult{JobID: jobID, Type: transport.JobResultFailure, Reason: transport.JobFailureReasonOther, Message: "broken"}
+	runJob(t, r, s, scheduler.Job{ID: jobID, Name: "failing"}, result)
+}
+
+func TestListAndRequeueDeadJobs(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	deadLetter(t, r, s, "dead-first")
+	time.Sleep(5 * time.Millisecond)
+	deadLetter(t, r, s, "dead-second")
+
+	deadJobs, err := s.ListDeadJobs(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadJobs) != 2 || deadJobs[0].Job.ID != "dead-second" || deadJobs[1].Job.ID != "dead-first" {
+		t.Fatalf("expected both dead jobs, most recent first, got %+v", deadJobs)
+	}
+	if deadJobs[1].Result.Message != "broken" {
+		t.Errorf("expected the final result to be kept, got %+v", deadJobs[1].Result)
+	}
+	if page, err := s.ListDeadJobs(ctx, 1, 10); err != nil || len(page) != 1 || page[0].Job.ID != "dead-first" {
+		t.Errorf("expected the second page to hold the first dead job, got %+v (%v)", page, err)
+	}
+
+	if err := s.RequeueDeadJob(ctx, "dead-first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ZScore(ctx, scheduler.RScoredJobSet, "dead-first").Result(); err != nil {
+		t.Errorf("expected the job to be queued again (%v)", err)
+	}
+	state, err := s.GetJobState(ctx, "dead-first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != scheduler.JobStatusQueued || state.RetryCount != 0 {
+		t.Errorf("expected the job to be queued with its retries reset, got %+v", state)
+	}
+	if _, err := s.GetDeadJob(ctx, "dead-first"); !errors.Is(err, scheduler.ErrDeadJobNotFound) {
+		t.Errorf("expected the requeued job to leave the dead-letter store, got %v", err)
+	}
+	if err := s.RequeueDeadJob(ctx, "dead-first"); !errors.Is(err, scheduler.ErrDeadJobNotFound) {
+		t.Errorf("requeueing twice: expected ErrDeadJobNotFound, got %v", err)
+	}
+}
+
+func TestPurgeDeadJobs(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	deadLetter(t, r, s, "purged-first")
+	deadLetter(t, r, s, "purged-second")
+
+	if err := s.PurgeDeadJob(ctx, "purged-first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetDeadJob(ctx, "purged-first"); !errors.Is(err, scheduler.ErrDeadJobNotFound) {
+		t.Errorf("expected the purged job to be gone, got %v", err)
+	}
+	if _, err := s.GetJobState(ctx, "purged-first"); !errors.Is(err, scheduler.ErrJobNotFound) {
+		t.Errorf("expected the state of the purged job to be gone, got %v", err)
+	}
+	if err := s.PurgeDeadJob(ctx, "purged-first"); !errors.Is(err, scheduler.ErrDeadJobNotFound) {
+		t.Errorf("purging twice: expected ErrDeadJobNotFound, got %v", err)
+	}
+
+	// dead jobs left by other tests are purged as well
+	purged, err := s.PurgeDeadJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged < 1 {
+		t.Errorf("expected the remaining dead job to be counted, got %d", purged)
+	}
+	if _, err := s.GetDeadJob(ctx, "purged-second"); !errors.Is(err, scheduler.ErrDeadJobNotFound) {
+		t.Errorf("expected the remaining dead job to be purged, got %v", err)
+	}
+	if _, err := s.GetJobState(ctx, "purged-second"); !errors.Is(err, scheduler.ErrJobNotFound) {
+		t.Errorf("expected the state of the remaining dead job to be gone, got %v", err)
+	}
+}
+
+func TestRequeueDeadJobReclaimsUniqueKey(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+	key := "requeued-" + t.Name()
+	cleanupUniqueKey(t, r, key)
+
+	dead := scheduler.Job{ID: "requeued-unique", Name: "failing", UniqueKey: key, UniqueMode: scheduler.UniqueWhileQueuedOrRunning}
+	runJob(t, r, s, dead, transport.JobResult{JobID: dead.ID, Type: transport.JobResultFailure, Reason: transport.JobFailureReasonOther, Message: "broken"})
+
+	// a duplicate took the key once the job died
+	if holderID := addUniqueJob(t, r, s, "requeued-duplicate", key); holderID != "requeued-duplicate" {
+		t.Fatalf("expected the duplicate to take the key, got %q", holderID)
+	}
+	if err := s.RequeueDeadJob(ctx, dead.ID); !errors.Is(err, scheduler.ErrDuplicateJob) {
+		t.Fatalf("expected ErrDuplicateJob, got %v", err)
+	}
+	if _, err := s.GetDeadJob(ctx, dead.ID); err != nil {
+		t.Fatalf("expected the job to stay dead (%v)", err)
+	}
+	if _, err := r.Get(ctx, transport.MakeJobKey(dead.ID)).Result(); err != redis.Nil {
+		t.Errorf("expected no job data for the job left dead, got %v", err)
+	}
+
+	// the duplicate went away
+	r.ZRem(ctx, scheduler.RScoredJobSet, "requeued-duplicate")
+	r.Del(ctx, transport.MakeJobKey("requeued-duplicate"))
+	if err := s.RequeueDeadJob(ctx, dead.ID); err != nil {
+		t.Fatal(err)
+	}
+	if holderID := addUniqueJob(t, r, s, "requeued-later", key); holderID != dead.ID {
+		t.Errorf("expected the requeued job to hold the key, got %q", holderID)
+	}
+}
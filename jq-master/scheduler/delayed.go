@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	RDelayedJobSet   = "jq:delayedJobSet"   // job id -> time the job becomes ready (unix millis)
+	RScheduledJobSet = "jq:scheduledJobSet" // job id -> time the job was scheduled to run at (unix millis)
+
+	DefaultPromoteInterval = 500 * time.Millisecond
+)
+
+// moves a job between sorted sets, unless someone else already did
+// KEYS[1]: source, KEYS[2]: destination
+// ARGV[1]: job id, ARGV[2]: score in the destination
+var moveJobScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 1 then
+	redis.call("ZADD", KEYS[2], ARGV[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// addDelayedJob stores the job and makes it eligible for distribution at readyAt.
+func (s *Scheduler) addDelayedJob(ctx context.Context, job Job, readyAt time.Time) error {
+	jobBin, err := msgpack.Marshal(&job)
+	if err != nil {
+		return err
+	}
+
+	tx := s.r.TxPipeline()
+	tx.Set(ctx, makeJobKey(job.ID), jobBin, 0)
+	tx.ZAdd(ctx, RDelayedJobSet, redis.Z{
+		Score:  float64(readyAt.UnixMilli()),
+		Member: job.ID,
+	})
+	_, err = tx.Exec(ctx)
+	return err
+}
+
+// parkJob moves a queued job to the delayed set until readyAt.
+// It reports false if the job was no longer queued.
+func (s *Scheduler) parkJob(ctx context.Context, jobID string, readyAt time.Time) (bool, error) {
+	n, err := moveJobScript.Run(ctx, s.r, []string{RScoredJobSet, RDelayedJobSet}, jobID, readyAt.UnixMilli()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// PromoteDelayedJobs periodically moves delayed and scheduled jobs whose time has come into the scored job set.
+// It also puts back jobs parked by a concurrency limit whose slots leaked.
+func (s *Scheduler) PromoteDelayedJobs(ctx context.Context) {
+	ticker := time.NewTicker(s.promoteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.promoteDueJobs(ctx, RScheduledJobSet); err != nil {
+				log.Printf("error promoting scheduled jobs: %v", err)
+			}
+			if err := s.promoteDueJobs(ctx, RDelayedJobSet); err != nil {
+				log.Printf("error promoting delayed jobs: %v", err)
+			}
+			if err := s.wakeLeakedJobs(ctx); err != nil {
+				log.Printf("error waking parked jobs: %v", err)
+			}
+		}
+	}
+}
+
+// promoteDueJobs moves the jobs of the given set whose time has come into the scored job set.
+func (s *Scheduler) promoteDueJobs(ctx context.Context, set string) error {
+	jobIDs, err := s.r.ZRangeByScore(ctx, set, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, jobID := range jobIDs {
+		job, err := s.getJob(ctx, jobID)
+		if errors.Is(err, redis.Nil) {
+			// job data is gone; nothing to promote
+			log.Printf("dropping job %s without job data from %s", jobID, set)
+			if err := s.r.ZRem(ctx, set, jobID).Err(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("error getting job %s from %s: %v", jobID, set, err)
+			continue
+		}
+
+		moved, err := moveJobScript.Run(ctx, s.r, []string{set, RScoredJobSet}, jobID, job.CalculatePriorityScore()).Int()
+		if err != nil {
+			return err
+		}
+		if moved == 1 {
+			if err := s.recordJobState(ctx, s.r, jobID, JobStatusQueued).Err(); err != nil {
+				log.Printf("error recording state of job %s: %v", jobID, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,307 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	RWaitingJobs = "jq:waitingJobs" // jobs held back until their parents succeed
+
+	// argument field through which a job receives the results of its parents, keyed by parent job id
+	ParentResultsArgument = "_parents"
+)
+
+var (
+	ErrUnknownParent     = errors.New("parent job not found")
+	ErrParentFailed      = errors.New("parent job failed")
+	ErrDependencyCycle   = errors.New("dependency cycle")
+	ErrDuplicateWorkflow = errors.New("duplicate job id in workflow")
+)
+
+func makeJobParentsKey(jobID string) string {
+	return "jq:jobParents:" + jobID
+}
+
+func makeJobChildrenKey(jobID string) string {
+	return "jq:jobChildren:" + jobID
+}
+
+func makeJobParentResultsKey(jobID string) string {
+	return "jq:jobParentResults:" + jobID
+}
+
+// registers a job waiting for its parents, which all have to be pending or succeeded;
+// the results of succeeded parents are recorded right away
+// KEYS[1]: job data, KEYS[2]: parents of the job, KEYS[3]: waiting jobs, KEYS[4]: parent results of the job,
+// KEYS[5]: dead jobs, KEYS[6...]: data, state and children of each parent, in the order of the parent ids
+// ARGV[1]: job id, ARGV[2]: job data, ARGV[3...]: parent ids
+// returns {0, ""} on success, {1, parent} for an unknown parent, {2, parent} for a failed parent,
+// {3, ""} if every parent already succeeded
+var registerDependentJobScript = redis.NewScript(`
+local pending = {}
+local succeeded = {}
+for i = 3, #ARGV do
+	local parent = {id = ARGV[i], job = KEYS[3 * i - 3], state = KEYS[3 * i - 2], children = KEYS[3 * i - 1]}
+	if redis.call("EXISTS", parent.job) == 1 then
+		table.insert(pending, parent)
+	elseif redis.call("HGET", parent.state, "status") == "done" then
+		table.insert(succeeded, parent)
+	elseif redis.call("ZSCORE", KEYS[5], parent.id) then
+		return {2, parent.id}
+	else
+		return {1, parent.id}
+	end
+end
+for _, parent in ipairs(succeeded) do
+	-- "\192" is a msgpack nil, for a parent whose result was not recorded
+	local result = redis.call("HGET", parent.state, "result") or "\192"
+	redis.call("HSET", KEYS[4], parent.id, result)
+end
+for _, parent in ipairs(pending) do
+	redis.call("SADD", KEYS[2], parent.id)
+	redis.call("SADD", parent.children, ARGV[1])
+end
+redis.call("SET", KEYS[1], ARGV[2])
+if #pending == 0 then
+	return {3, ""}
+end
+redis.call("SADD", KEYS[3], ARGV[1])
+return {0, ""}
+`)
+
+// records the result of a parent and reports whether the child has no pending parent left
+// KEYS[1]: parents of the child, KEYS[2]: waiting jobs, KEYS[3]: parent results of the child
+// ARGV[1]: child id, ARGV[2]: parent id, ARGV[3]: parent result
+var resolveParentScript = redis.NewScript(`
+if redis.call("SREM", KEYS[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[3], ARGV[2], ARGV[3])
+if redis.call("SCARD", KEYS[1]) == 0 and redis.call("SREM", KEYS[2], ARGV[1]) == 1 then
+	return 1
+end
+return 0
+`)
+
+// addDependentJob stores a job that has to wait until all of its parents succeed.
+func (s *Scheduler) addDependentJob(ctx context.Context, job Job) error {
+	jobBin, err := msgpack.Marshal(&job)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{makeJobKey(job.ID), makeJobParentsKey(job.ID), RWaitingJobs, makeJobParentResultsKey(job.ID), RDeadJobSet}
+	args := []interface{}{job.ID, jobBin}
+	seen := make(map[string]bool, len(job.DependsOn))
+	for _, parentID := range job.DependsOn {
+		if parentID == job.ID {
+			return ErrDependencyCycle
+		}
+		if !seen[parentID] {
+			seen[parentID] = true
+			keys = append(keys, makeJobKey(parentID), makeJobStateKey(parentID), makeJobChildrenKey(parentID))
+			args = append(args, parentID)
+		}
+	}
+
+	res, err := registerDependentJobScript.Run(ctx, s.r, keys, args...).Slice()
+	if err != nil {
+		return err
+	}
+	switch res[0].(int64) {
+	case 1:
+		return fmt.Errorf("%w: %v", ErrUnknownParent, res[1])
+	case 2:
+		return fmt.Errorf("%w: %v", ErrParentFailed, res[1])
+	case 3:
+		// nothing to wait for
+		return s.enqueueReleasedJob(ctx, job.ID)
+	}
+
+	// the parents may already have released the job; that state is not overwritten
+	if err := s.recordJobState(ctx, s.r, job.ID, JobStatusWaiting).Err(); err != nil {
+		log.Printf("error recording state of job %s: %v", job.ID, err)
+	}
+	return nil
+}
+
+// AddWorkflow enqueues a set of jobs whose DependsOn may refer to each other or to pending or succeeded jobs.
+// Jobs are added parents first; the workflow is rejected if it contains a cycle.
+// Adding is not atomic: on error, the jobs added before it stay enqueued.
+func (s *Scheduler) AddWorkflow(ctx context.Context, jobs []Job) error {
+	byID := make(map[string]Job, len(jobs))
+	for _, job := range jobs {
+		if _, ok := byID[job.ID]; ok {
+			return fmt.Errorf("%w: %s", ErrDuplicateWorkflow, job.ID)
+		}
+		byID[job.ID] = job
+	}
+
+	// depth-first topological sort
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(jobs))
+	ordered := make([]Job, 0, len(jobs))
+	var visit func(job Job) error
+	visit = func(job Job) error {
+		switch state[job.ID] {
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrDependencyCycle, job.ID)
+		case visited:
+			return nil
+		}
+		state[job.ID] = visiting
+		for _, parentID := range job.DependsOn {
+			if parent, ok := byID[parentID]; ok {
+				if err := visit(parent); err != nil {
+					return err
+				}
+			}
+		}
+		state[job.ID] = visited
+		ordered = append(ordered, job)
+		return nil
+	}
+	for _, job := range jobs {
+		if err := visit(job); err != nil {
+			return err
+		}
+	}
+
+	for _, job := range ordered {
+		if _, err := s.AddJob(ctx, job); err != nil {
+			return fmt.Errorf("failed to add job %s: %w", job.ID, err)
+		}
+	}
+	return nil
+}
+
+// releaseChildren hands the result of a succeeded job to its children and enqueues those with no pending parent left.
+func (s *Scheduler) releaseChildren(ctx context.Context, result transport.JobResult) error {
+	childrenKey := makeJobChildrenKey(result.JobID)
+	children, err := s.r.SMembers(ctx, childrenKey).Result()
+	if err != nil {
+		return err
+	}
+
+	resultBin, err := msgpack.Marshal(result.Result)
+	if err != nil {
+		return err
+	}
+
+	for _, childID := range children {
+		ready, err := resolveParentScript.Run(ctx, s.r,
+			[]string{makeJobParentsKey(childID), RWaitingJobs, makeJobParentResultsKey(childID)},
+			childID, result.JobID, resultBin,
+		).Int()
+		if err != nil {
+			return err
+		}
+		if ready == 0 {
+			continue
+		}
+
+		if err := s.enqueueReleasedJob(ctx, childID); err != nil {
+			log.Printf("error enqueueing job %s after its parents succeeded: %v", childID, err)
+		}
+	}
+
+	return s.r.Del(ctx, childrenKey).Err()
+}
+
+// enqueueReleasedJob enqueues a job whose parents all succeeded, with their results in its argument.
+func (s *Scheduler) enqueueReleasedJob(ctx context.Context, jobID string) error {
+	job, err := s.getJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+
+	parentResults, err := s.r.HGetAll(ctx, makeJobParentResultsKey(jobID)).Result()
+	if err != nil {
+		return err
+	}
+	results := make(map[string]interface{}, len(parentResults))
+	for parentID, resultBin := range parentResults {
+		var result interface{}
+		if err := msgpack.Unmarshal([]byte(resultBin), &result); err != nil {
+			return err
+		}
+		results[parentID] = result
+	}
+
+	if job.Argument == nil {
+		job.Argument = make(map[string]interface{})
+	}
+	job.Argument[ParentResultsArgument] = results
+
+	if err := s.enqueueJob(ctx, job); err != nil {
+		return err
+	}
+	return s.r.Del(ctx, makeJobParentsKey(jobID), makeJobParentResultsKey(jobID)).Err()
+}
+
+// failChildren dead-letters every job waiting (directly or indirectly) for a job that failed for good.
+func (s *Scheduler) failChildren(ctx context.Context, jobID string) error {
+	childrenKey := makeJobChildrenKey(jobID)
+	children, err := s.r.SMembers(ctx, childrenKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, childID := range children {
+		// claim the child so that it is failed only once
+		n, err := s.r.SRem(ctx, RWaitingJobs, childID).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+
+		if err := s.failWaitingJob(ctx, childID, fmt.Sprintf("dependency %s failed", jobID)); err != nil {
+			log.Printf("error failing job %s: %v", childID, err)
+			continue
+		}
+		if err := s.failChildren(ctx, childID); err != nil {
+			return err
+		}
+	}
+
+	return s.r.Del(ctx, childrenKey).Err()
+}
+
+// failWaitingJob dead-letters a waiting job with a synthesized failure and reports it to the pusher.
+func (s *Scheduler) failWaitingJob(ctx context.Context, jobID, message string) error {
+	job, err := s.getJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+
+	result := transport.JobResult{
+		JobID:      jobID,
+		Type:       transport.JobResultFailure,
+		FinishedAt: time.Now().Format(time.RFC3339),
+		Reason:     transport.JobFailureReasonOther,
+		Message:    message,
+	}
+	if err := s.r.Del(ctx, makeJobParentsKey(jobID), makeJobParentResultsKey(jobID)).Err(); err != nil {
+		return err
+	}
+	if err := s.addDeadJob(ctx, job, result); err != nil {
+		return err
+	}
+	if err := s.releaseUniqueKey(ctx, job); err != nil {
+		log.Printf("error releasing unique key of job %s: %v", jobID, err)
+	}
+	return s.publishFinalResult(ctx, job, result)
+}
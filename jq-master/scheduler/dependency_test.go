@@ -0,0 +1,74 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+)
+
+// workflows rejected before anything is written do not need Redis
+func TestAddWorkflowRejectsInvalid(t *testing.T) {
+	s := scheduler.NewScheduler(nil, nil)
+
+	cycle := []scheduler.Job{
+		{ID: "download"},
+		{ID: "transcode", DependsOn: []string{"download", "publish"}},
+		{ID: "publish", DependsOn: []string{"transcode"}},
+	}
+	if err := s.AddWorkflow(context.Background(), cycle); !errors.Is(err, scheduler.ErrDependencyCycle) {
+		t.Errorf("expected a dependency cycle error, got %v", err)
+	}
+
+	duplicate := []scheduler.Job{
+		{ID: "download"},
+		{ID: "download"},
+	}
+	if err := s.AddWorkflow(context.Background(), duplicate); !errors.Is(err, scheduler.ErrDuplicateWorkflow) {
+		t.Errorf("expected a duplicate job error, got %v", err)
+	}
+}
+
+func TestDependentOfSucceededParentIsEnqueued(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	parent := scheduler.Job{ID: "succeeded-parent", Name: "parent"}
+	child := scheduler.Job{ID: "late-child", Name: "child", DependsOn: []string{parent.ID}}
+	cleanupJob(t, r, parent.ID)
+	cleanupJob(t, r, child.ID)
+
+	if _, err := s.AddJob(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := popJob(s, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// as recorded when the parent is dispatched
+	r.SAdd(ctx, scheduler.RProcessingJobs, parent.ID)
+	r.HSet(ctx, "jq:jobState:"+parent.ID, "status", scheduler.JobStatusRunning)
+	if err := s.ProcessResult(ctx, transport.JobResult{JobID: parent.ID, Type: transport.JobResultSuccess, Result: "parent output"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the parent is gone, but it succeeded
+	if _, err := s.AddJob(ctx, child); err != nil {
+		t.Fatal(err)
+	}
+	job, err := popJob(s, time.Second)
+	if err != nil {
+		t.Fatalf("expected the child to be enqueued right away: %v", err)
+	}
+	if job.ID != child.ID {
+		t.Fatalf("expected %s, got %s", child.ID, job.ID)
+	}
+	results, _ := job.Argument[scheduler.ParentResultsArgument].(map[string]interface{})
+	if results[parent.ID] != "parent output" {
+		t.Errorf("expected the result of the parent, got %v", job.Argument[scheduler.ParentResultsArgument])
+	}
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Fence tells whether the scheduler may still act for the leading master.
+type Fence interface {
+	// Check returns an error once the term is over, reading through c.
+	Check(ctx context.Context, c redis.Scripter) error
+	// Keys lists the keys Check reads, so that a transaction can watch them.
+	Keys() []string
+}
+
+// ErrFenced is returned for writes refused because the fence no longer holds.
+var ErrFenced = errors.New("fenced out")
+
+// fenceHolder lets fences of different types be stored in the same atomic.Value.
+type fenceHolder struct {
+	fence Fence
+}
+
+// SetFence makes dispatching and result processing stop once the fence reports an error.
+// Loops of a previous term may still be running, so the fence is swapped atomically.
+func (s *Scheduler) SetFence(fence Fence) {
+	s.fence.Store(fenceHolder{fence: fence})
+}
+
+func (s *Scheduler) loadFence() Fence {
+	holder, ok := s.fence.Load().(fenceHolder)
+	if !ok {
+		return nil
+	}
+	return holder.fence
+}
+
+func (s *Scheduler) checkFence(ctx context.Context) error {
+	fence := s.loadFence()
+	if fence == nil {
+		return nil
+	}
+	if err := fence.Check(ctx, s.r); err != nil {
+		return fmt.Errorf("%w: %v", ErrFenced, err)
+	}
+	return nil
+}
+
+// fencedTxPipelined runs the commands queued by fn in a transaction that commits only while the fence holds.
+// The fence is checked on a connection watching its keys, so a master that lost its term between
+// the check and the commit writes nothing.
+func (s *Scheduler) fencedTxPipelined(ctx context.Context, fn func(pipe redis.Pipeliner) error) error {
+	fence := s.loadFence()
+	if fence == nil {
+		_, err := s.r.TxPipelined(ctx, fn)
+		return err
+	}
+
+	for {
+		err := s.r.Watch(ctx, func(tx *redis.Tx) error {
+			if err := fence.Check(ctx, tx); err != nil {
+				return fmt.Errorf("%w: %v", ErrFenced, err)
+			}
+			_, err := tx.TxPipelined(ctx, fn)
+			return err
+		}, fence.Keys()...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+		// the lease was touched (e.g. renewed) in the meantime; check again
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+// stealingFence holds while key names id; another master takes key over right after the check numbered stealAt.
+type stealingFence struct {
+	r       *redis.Client
+	key, id string
+	checks  int
+	stealAt int
+}
+
+func (f *stealingFence) Check(ctx context.Context, c redis.Scripter) error {
+	f.checks++
+	holder, err := c.Eval(ctx, `return redis.call("GET", KEYS[1])`, []string{f.key}).Text()
+	if err != nil {
+		return err
+	}
+	if holder != f.id {
+		return errors.New("fenced")
+	}
+	if f.checks == f.stealAt {
+		f.r.Set(ctx, f.key, "other", 0)
+	}
+	return nil
+}
+
+func (f *stealingFence) Keys() []string {
+	return []string{f.key}
+}
+
+func TestFenceIsCheckedWithTheWrite(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	job := scheduler.Job{ID: "fenced-job", Name: "job", MaxRetry: 1}
+	cleanupJob(t, r, job.ID)
+	if _, err := s.AddJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	// as if dispatched
+	r.ZRem(ctx, scheduler.RScoredJobSet, job.ID)
+	r.SAdd(ctx, scheduler.RProcessingJobs, job.ID)
+
+	fence := &stealingFence{r: r, key: "test:" + t.Name(), id: "me", stealAt: 2}
+	r.Set(ctx, fence.key, fence.id, 0)
+	t.Cleanup(func() {
+		r.Del(context.Background(), fence.key)
+	})
+	s.SetFence(fence)
+
+	// the first check passes; the term ends between the second one and the write
+	result := transport.JobResult{JobID: job.ID, Type: transport.JobResultSuccess}
+	if err := s.ProcessResult(ctx, result); !errors.Is(err, scheduler.ErrFenced) {
+		t.Fatalf("expected the result to be fenced out, got %v", err)
+	}
+	if !r.SIsMember(ctx, scheduler.RProcessingJobs, job.ID).Val() {
+		t.Error("a fenced out master finished the attempt")
+	}
+
+	// the result is left to the master leading now
+	results, err := r.LRange(ctx, transport.RResultQueue, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handedBack := false
+	for _, res := range results {
+		if strings.Contains(res, job.ID) {
+			handedBack = true
+			r.LRem(ctx, transport.RResultQueue, 0, res)
+		}
+	}
+	if !handedBack {
+		t.Error("expected the result to be handed back")
+	}
+}
+
+func TestFencedDispatchReleasesConstraints(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	name := "fenced-" + t.Name()
+	cleanupConcurrency(t, r, name)
+	newScheduler := func() *scheduler.Scheduler {
+		s := scheduler.NewScheduler(r, transport.NewConn(r))
+		s.AddConcurrencyLimit(name, scheduler.JobNameMatcher("fenced"), 1)
+		return s
+	}
+
+	job := scheduler.Job{ID: "fenced-dispatch-job", Name: "fenced"}
+	cleanupJob(t, r, job.ID)
+	s := newScheduler()
+	if err := s.AddWorker(ctx, scheduler.NewWorker("fenced-worker", "worker", 1)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.RemoveWorker(context.Background(), "fenced-worker")
+	})
+	if _, err := s.AddJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	// the term ends between popping the job and tracking its attempt
+	fence := &stealingFence{r: r, key: "test:" + t.Name(), id: "me", stealAt: 1}
+	r.Set(ctx, fence.key, fence.id, 0)
+	t.Cleanup(func() {
+		r.Del(context.Background(), fence.key)
+	})
+	s.SetFence(fence)
+	go s.DistributeJobs(ctx, make(chan transport.JobResult))
+	for r.Get(ctx, fence.key).Val() == fence.id {
+		if ctx.Err() != nil {
+			t.Fatal("the job was never popped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for r.ZScore(ctx, scheduler.RScoredJobSet, job.ID).Err() != nil {
+		if ctx.Err() != nil {
+			t.Fatal("the job was never put back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the master leading now may run another job in the slot
+	other := scheduler.Job{ID: "fenced-other-job", Name: "fenced", Priority: -1}
+	cleanupJob(t, r, other.ID)
+	next := newScheduler()
+	if _, err := next.AddJob(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	popped, err := popJob(next, time.Second)
+	if err != nil {
+		t.Fatalf("expected the slot to be free again: %v", err)
+	}
+	if popped.ID != other.ID {
+		t.Errorf("unexpected job %s", popped.ID)
+	}
+}
@@ -0,0 +1,276 @@
+package scheduler
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// JobMatcher selects the jobs a constraint applies to.
+type JobMatcher interface {
+	Match(job Job) bool
+}
+
+// JobNameMatcher matches jobs with exactly the given name.
+type JobNameMatcher string
+
+func (m JobNameMatcher) Match(job Job) bool {
+	return job.Name == string(m)
+}
+
+// JobNamePrefixMatcher matches jobs whose name starts with the given prefix.
+type JobNamePrefixMatcher string
+
+func (m JobNamePrefixMatcher) Match(job Job) bool {
+	return strings.HasPrefix(job.Name, string(m))
+}
+
+// JobNameGlobMatcher matches job names against a glob pattern (see path.Match).
+type JobNameGlobMatcher string
+
+func (m JobNameGlobMatcher) Match(job Job) bool {
+	ok, err := path.Match(string(m), job.Name)
+	return err == nil && ok
+}
+
+// ArgumentEqualsMatcher matches jobs whose argument field equals Value.
+// Numbers are compared by value regardless of their encoded type.
+type ArgumentEqualsMatcher struct {
+	Field string
+	Value interface{}
+}
+
+func (m ArgumentEqualsMatcher) Match(job Job) bool {
+	v, ok := lookupArgument(job.Argument, m.Field)
+	if !ok {
+		return false
+	}
+	return valuesEqual(v, m.Value)
+}
+
+// ArgumentExistsMatcher matches jobs that have the argument field.
+type ArgumentExistsMatcher struct {
+	Field string
+}
+
+func (m ArgumentExistsMatcher) Match(job Job) bool {
+	_, ok := lookupArgument(job.Argument, m.Field)
+	return ok
+}
+
+// ArgumentRangeMatcher matches jobs whose numeric argument field lies within [Min, Max].
+// A nil bound is unbounded.
+type ArgumentRangeMatcher struct {
+	Field string
+	Min   *float64
+	Max   *float64
+}
+
+func (m ArgumentRangeMatcher) Match(job Job) bool {
+	v, ok := lookupArgument(job.Argument, m.Field)
+	if !ok {
+		return false
+	}
+	n, ok := toFloat64(v)
+	if !ok {
+		return false
+	}
+	if m.Min != nil && n < *m.Min {
+		return false
+	}
+	if m.Max != nil && n > *m.Max {
+		return false
+	}
+	return true
+}
+
+// AndMatcher matches jobs matched by all of its matchers.
+type AndMatcher []JobMatcher
+
+func (m AndMatcher) Match(job Job) bool {
+	for _, child := range m {
+		if !child.Match(job) {
+			return false
+		}
+	}
+	return true
+}
+
+// OrMatcher matches jobs matched by any of its matchers.
+type OrMatcher []JobMatcher
+
+func (m OrMatcher) Match(job Job) bool {
+	for _, child := range m {
+		if child.Match(job) {
+			return true
+		}
+	}
+	return false
+}
+
+// NotMatcher matches jobs its matcher does not match.
+type NotMatcher struct {
+	Matcher JobMatcher
+}
+
+func (m NotMatcher) Match(job Job) bool {
+	return !m.Matcher.Match(job)
+}
+
+// AnyMatcher matches every job.
+type AnyMatcher struct{}
+
+func (m AnyMatcher) Match(job Job) bool {
+	return true
+}
+
+// lookupArgument resolves a dot-separated field path (e.g. "user.id") in the job argument.
+func lookupArgument(argument map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = argument
+	for _, part := range strings.Split(field, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+func valuesEqual(a, b interface{}) bool {
+	an, aok := toFloat64(a)
+	bn, bok := toFloat64(b)
+	if aok && bok {
+		return an == bn
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+const (
+	MatcherAny        = "any"
+	MatcherName       = "name"
+	MatcherNamePrefix = "name_prefix"
+	MatcherNameGlob   = "name_glob"
+	MatcherEquals     = "equals"
+	MatcherExists     = "exists"
+	MatcherRange      = "range"
+	MatcherAnd        = "and"
+	MatcherOr         = "or"
+	MatcherNot        = "not"
+)
+
+// MatcherSpec is the serializable form of a JobMatcher, used in config files and API requests.
+//
+// Examples (JSON):
+//
+//	{"type": "name_glob", "value": "send-*"}
+//	{"type": "and", "matchers": [{"type": "name", "value": "send-email"}, {"type": "equals", "field": "domain", "value": "example.com"}]}
+type MatcherSpec struct {
+	Type     string        `json:"type" msgpack:"type"`
+	Field    string        `json:"field,omitempty" msgpack:"field,omitempty"`       // argument field for equals, exists and range
+	Value    interface{}   `json:"value,omitempty" msgpack:"value,omitempty"`       // name or pattern for name matchers, expected value for equals
+	Min      *float64      `json:"min,omitempty" msgpack:"min,omitempty"`           // lower bound for range
+	Max      *float64      `json:"max,omitempty" msgpack:"max,omitempty"`           // upper bound for range
+	Matchers []MatcherSpec `json:"matchers,omitempty" msgpack:"matchers,omitempty"` // children for and, or and not
+}
+
+// Compile builds the JobMatcher described by the spec.
+func (spec MatcherSpec) Compile() (JobMatcher, error) {
+	switch spec.Type {
+	case MatcherAny:
+		return AnyMatcher{}, nil
+	case MatcherName, MatcherNamePrefix, MatcherNameGlob:
+		name, ok := spec.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s matcher requires a string value", spec.Type)
+		}
+		switch spec.Type {
+		case MatcherName:
+			return JobNameMatcher(name), nil
+		case MatcherNamePrefix:
+			return JobNamePrefixMatcher(name), nil
+		default:
+			if _, err := path.Match(name, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob pattern %q: %w", name, err)
+			}
+			return JobNameGlobMatcher(name), nil
+		}
+	case MatcherEquals:
+		if spec.Field == "" {
+			return nil, fmt.Errorf("equals matcher requires a field")
+		}
+		return ArgumentEqualsMatcher{Field: spec.Field, Value: spec.Value}, nil
+	case MatcherExists:
+		if spec.Field == "" {
+			return nil, fmt.Errorf("exists matcher requires a field")
+		}
+		return ArgumentExistsMatcher{Field: spec.Field}, nil
+	case MatcherRange:
+		if spec.Field == "" {
+			return nil, fmt.Errorf("range matcher requires a field")
+		}
+		if spec.Min == nil && spec.Max == nil {
+			return nil, fmt.Errorf("range matcher requires min or max")
+		}
+		return ArgumentRangeMatcher{Field: spec.Field, Min: spec.Min, Max: spec.Max}, nil
+	case MatcherAnd, MatcherOr:
+		children := make([]JobMatcher, 0, len(spec.Matchers))
+		for _, childSpec := range spec.Matchers {
+			child, err := childSpec.Compile()
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+		}
+		if spec.Type == MatcherAnd {
+			return AndMatcher(children), nil
+		}
+		return OrMatcher(children), nil
+	case MatcherNot:
+		if len(spec.Matchers) != 1 {
+			return nil, fmt.Errorf("not matcher requires exactly one child")
+		}
+		child, err := spec.Matchers[0].Compile()
+		if err != nil {
+			return nil, err
+		}
+		return NotMatcher{Matcher: child}, nil
+	default:
+		return nil, fmt.Errorf("unknown matcher type: %q", spec.Type)
+	}
+}
@@ -0,0 +1,129 @@
+package scheduler_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestJobMatchers(t *testing.T) {
+	job := scheduler.Job{
+		Name: "send-email",
+		Argument: map[string]interface{}{
+			"domain": "example.com",
+			"size":   int8(42),
+			"user": map[string]interface{}{
+				"id": uint16(7),
+			},
+		},
+	}
+
+	cases := []struct {
+		name    string
+		matcher scheduler.JobMatcher
+		want    bool
+	}{
+		{"name", scheduler.JobNameMatcher("send-email"), true},
+		{"name mismatch", scheduler.JobNameMatcher("send"), false},
+		{"prefix", scheduler.JobNamePrefixMatcher("send-"), true},
+		{"glob", scheduler.JobNameGlobMatcher("send-*"), true},
+		{"glob mismatch", scheduler.JobNameGlobMatcher("recv-*"), false},
+		{"equals", scheduler.ArgumentEqualsMatcher{Field: "domain", Value: "example.com"}, true},
+		{"equals numeric", scheduler.ArgumentEqualsMatcher{Field: "size", Value: 42.0}, true},
+		{"equals nested", scheduler.ArgumentEqualsMatcher{Field: "user.id", Value: 7}, true},
+		{"equals missing", scheduler.ArgumentEqualsMatcher{Field: "missing", Value: nil}, false},
+		{"exists", scheduler.ArgumentExistsMatcher{Field: "user.id"}, true},
+		{"exists missing", scheduler.ArgumentExistsMatcher{Field: "domain.id"}, false},
+		{"range", scheduler.ArgumentRangeMatcher{Field: "size", Min: ptr(10), Max: ptr(42)}, true},
+		{"range below", scheduler.ArgumentRangeMatcher{Field: "size", Min: ptr(43)}, false},
+		{"range non numeric", scheduler.ArgumentRangeMatcher{Field: "domain", Min: ptr(0)}, false},
+		{"and", scheduler.AndMatcher{scheduler.JobNameMatcher("send-email"), scheduler.ArgumentExistsMatcher{Field: "domain"}}, true},
+		{"and mismatch", scheduler.AndMatcher{scheduler.JobNameMatcher("send-email"), scheduler.ArgumentExistsMatcher{Field: "nope"}}, false},
+		{"or", scheduler.OrMatcher{scheduler.JobNameMatcher("other"), scheduler.ArgumentExistsMatcher{Field: "domain"}}, true},
+		{"not", scheduler.NotMatcher{Matcher: scheduler.JobNameMatcher("send-email")}, false},
+		{"any", scheduler.AnyMatcher{}, true},
+	}
+
+	for _, c := range cases {
+		if got := c.matcher.Match(job); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestMatcherSpecJSON(t *testing.T) {
+	data := []byte(`{
+		"type": "and",
+		"matchers": [
+			{"type": "name_glob", "value": "send-*"},
+			{"type": "not", "matchers": [{"type": "equals", "field": "domain", "value": "example.com"}]},
+			{"type": "range", "field": "size", "max": 100}
+		]
+	}`)
+
+	var spec scheduler.MatcherSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := spec.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matching := scheduler.Job{Name: "send-sms", Argument: map[string]interface{}{"domain": "example.org", "size": 3}}
+	if !matcher.Match(matching) {
+		t.Error("expected job to match")
+	}
+	other := scheduler.Job{Name: "send-sms", Argument: map[string]interface{}{"domain": "example.com", "size": 3}}
+	if matcher.Match(other) {
+		t.Error("expected job not to match")
+	}
+}
+
+func TestMatcherSpecMsgpack(t *testing.T) {
+	spec := scheduler.MatcherSpec{
+		Type: scheduler.MatcherOr,
+		Matchers: []scheduler.MatcherSpec{
+			{Type: scheduler.MatcherName, Value: "a"},
+			{Type: scheduler.MatcherNamePrefix, Value: "b-"},
+		},
+	}
+	bin, err := msgpack.Marshal(&spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded scheduler.MatcherSpec
+	if err := msgpack.Unmarshal(bin, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := decoded.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matcher.Match(scheduler.Job{Name: "b-1"}) || matcher.Match(scheduler.Job{Name: "c"}) {
+		t.Error("decoded matcher behaves differently")
+	}
+}
+
+func TestMatcherSpecInvalid(t *testing.T) {
+	specs := []scheduler.MatcherSpec{
+		{Type: "unknown"},
+		{Type: scheduler.MatcherName},
+		{Type: scheduler.MatcherNameGlob, Value: "["},
+		{Type: scheduler.MatcherEquals, Value: 1},
+		{Type: scheduler.MatcherRange, Field: "size"},
+		{Type: scheduler.MatcherNot},
+		{Type: scheduler.MatcherAnd, Matchers: []scheduler.MatcherSpec{{Type: "unknown"}}},
+	}
+	for _, spec := range specs {
+		if _, err := spec.Compile(); err == nil {
+			t.Errorf("expected error compiling %+v", spec)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"net/http"
+	"time"
+)
+
+// SchedulerOption is an interface that defines the apply method
+type SchedulerOption interface {
+	apply(*Scheduler)
+}
+
+type reapIntervalOption time.Duration
+
+func (o reapIntervalOption) apply(s *Scheduler) {
+	s.reapInterval = time.Duration(o)
+}
+
+// WithReapInterval sets how often the scheduler looks for timed out jobs.
+func WithReapInterval(interval time.Duration) SchedulerOption {
+	return reapIntervalOption(interval)
+}
+
+type promoteIntervalOption time.Duration
+
+func (o promoteIntervalOption) apply(s *Scheduler) {
+	s.promoteInterval = time.Duration(o)
+}
+
+// WithPromoteInterval sets how often the scheduler looks for delayed jobs that became ready.
+func WithPromoteInterval(interval time.Duration) SchedulerOption {
+	return promoteIntervalOption(interval)
+}
+
+type backoffOption BackoffConfig
+
+func (o backoffOption) apply(s *Scheduler) {
+	s.backoff = BackoffConfig(o)
+}
+
+// WithBackoff sets the backoff applied between retries of a failed job.
+func WithBackoff(config BackoffConfig) SchedulerOption {
+	return backoffOption(config)
+}
+
+type recurringIntervalOption time.Duration
+
+func (o recurringIntervalOption) apply(s *Scheduler) {
+	s.recurringInterval = time.Duration(o)
+}
+
+// WithRecurringInterval sets how often the scheduler looks for recurring jobs whose tick has come.
+func WithRecurringInterval(interval time.Duration) SchedulerOption {
+	return recurringIntervalOption(interval)
+}
+
+type stateTTLOption time.Duration
+
+func (o stateTTLOption) apply(s *Scheduler) {
+	s.stateTTL = time.Duration(o)
+}
+
+// WithStateTTL sets how long the state of a finished or cancelled job is kept.
+func WithStateTTL(ttl time.Duration) SchedulerOption {
+	return stateTTLOption(ttl)
+}
+
+type resultTTLOption time.Duration
+
+func (o resultTTLOption) apply(s *Scheduler) {
+	s.resultTTL = time.Duration(o)
+}
+
+// WithResultTTL sets how long the result of a keep_result job is kept if nobody takes it.
+func WithResultTTL(ttl time.Duration) SchedulerOption {
+	return resultTTLOption(ttl)
+}
+
+type httpClientOption struct {
+	client *http.Client
+}
+
+func (o httpClientOption) apply(s *Scheduler) {
+	s.httpClient = o.client
+}
+
+// WithHTTPClient sets the client used to push jobs to push workers.
+func WithHTTPClient(client *http.Client) SchedulerOption {
+	return httpClientOption{client: client}
+}
+
+type countOrphanedAttemptsOption bool
+
+func (o countOrphanedAttemptsOption) apply(s *Scheduler) {
+	s.countOrphanedAttempts = bool(o)
+}
+
+// WithCountOrphanedAttempts sets whether an attempt cut short by the loss of its worker uses up a retry.
+// Counting it keeps a job that brings its worker down from being retried forever.
+func WithCountOrphanedAttempts(count bool) SchedulerOption {
+	return countOrphanedAttemptsOption(count)
+}
+
+type maxPushesInFlightOption int
+
+func (o maxPushesInFlightOption) apply(s *Scheduler) {
+	s.maxPushesInFlight = int(o)
+}
+
+// WithMaxPushesInFlight sets how many jobs may be being pushed to the same push worker at once.
+func WithMaxPushesInFlight(n int) SchedulerOption {
+	return maxPushesInFlightOption(n)
+}
+
+type maxPushFailuresOption int
+
+func (o maxPushFailuresOption) apply(s *Scheduler) {
+	s.maxPushFailures = int(o)
+}
+
+// WithMaxPushFailures sets after how many failed pushes in a row a push worker is considered lost and dropped.
+func WithMaxPushFailures(n int) SchedulerOption {
+	return maxPushFailuresOption(n)
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+const RJobOwners = "jq:jobOwners" // job id -> id of the worker running the current attempt
+
+// makeOwnedJobsKey returns the set of jobs whose current attempt the worker runs, the reverse of RJobOwners.
+func makeOwnedJobsKey(workerID string) string {
+	return "jq:ownedJobs:" + workerID
+}
+
+// setJobOwner records which worker runs the current attempt of the job.
+func (s *Scheduler) setJobOwner(ctx context.Context, jobID, workerID string) error {
+	tx := s.r.TxPipeline()
+	tx.HSet(ctx, RJobOwners, jobID, workerID)
+	tx.SAdd(ctx, makeOwnedJobsKey(workerID), jobID)
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+// clears the owner of the job if it is still the worker
+// KEYS[1]: job owners, KEYS[2]: jobs owned by the worker
+// ARGV[1]: job id, ARGV[2]: worker id
+var clearJobOwnerScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+	redis.call("SREM", KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+// clearJobOwner forgets the worker as the owner of an attempt it did not take.
+func (s *Scheduler) clearJobOwner(ctx context.Context, jobID, workerID string) error {
+	return clearJobOwnerScript.Run(ctx, s.r, []string{RJobOwners, makeOwnedJobsKey(workerID)}, jobID, workerID).Err()
+}
+
+// getJobOwner returns the worker running the current attempt of the job, or "" if it is not known.
+func (s *Scheduler) getJobOwner(ctx context.Context, jobID string) (string, error) {
+	owners, err := s.r.HMGet(ctx, RJobOwners, jobID).Result()
+	if err != nil {
+		return "", err
+	}
+	owner, _ := owners[0].(string)
+	return owner, nil
+}
+
+// records the worker as the owner of the jobs it took from the global queue that have no owner yet;
+// entries of jobs that are no longer processing, or wait in the global queue again, are leftovers of earlier attempts
+// KEYS[1]: in-flight list of the worker, KEYS[2]: processing jobs, KEYS[3]: job owners, KEYS[4]: global queue,
+// KEYS[5]: jobs owned by the worker
+// ARGV[1]: worker id
+var adoptInFlightJobsScript = redis.NewScript(`
+for _, jobID in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
+	if redis.call("SISMEMBER", KEYS[2], jobID) == 1 and not redis.call("LPOS", KEYS[4], jobID)
+		and redis.call("HSETNX", KEYS[3], jobID, ARGV[1]) == 1 then
+		redis.call("SADD", KEYS[5], jobID)
+	end
+end
+return 0
+`)
+
+// adoptInFlightJobsOf records the worker as the owner of the attempts in its in-flight list.
+// Pulling workers only move the jobs they take into that list, so their ownership is learned from it.
+func (s *Scheduler) adoptInFlightJobsOf(ctx context.Context, workerID string) error {
+	return adoptInFlightJobsScript.Run(ctx, s.r,
+		[]string{transport.MakeInFlightKey(workerID), RProcessingJobs, RJobOwners, transport.RGlobalQueue, makeOwnedJobsKey(workerID)},
+		workerID,
+	).Err()
+}
+
+// adoptInFlightJobs records the owners of the attempts taken by the registered pulling workers.
+func (s *Scheduler) adoptInFlightJobs(ctx context.Context) error {
+	s.workersMutex.Lock()
+	var workerIDs []string
+	for _, w := range s.workers {
+		if !w.IsPush() {
+			workerIDs = append(workerIDs, w.ID)
+		}
+	}
+	s.workersMutex.Unlock()
+
+	for _, workerID := range workerIDs {
+		if err := s.adoptInFlightJobsOf(ctx, workerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobsOwnedBy returns the in-flight jobs run by the worker.
+func (s *Scheduler) jobsOwnedBy(ctx context.Context, workerID string) ([]string, error) {
+	return s.r.SMembers(ctx, makeOwnedJobsKey(workerID)).Result()
+}
+
+// recoverOrphanedJobs retries the jobs a lost worker was running,
+// including the ones it took from the global queue without acknowledging them.
+func (s *Scheduler) recoverOrphanedJobs(ctx context.Context, workerID string) error {
+	// the worker may have been lost before its latest attempts were adopted
+	if err := s.adoptInFlightJobsOf(ctx, workerID); err != nil {
+		return err
+	}
+	jobIDs, err := s.jobsOwnedBy(ctx, workerID)
+	if err != nil {
+		return err
+	}
+
+	for _, jobID := range jobIDs {
+		if err := s.recoverOrphanedJob(ctx, jobID, workerID); err != nil {
+			log.Printf("error recovering job %s of worker %s: %v", jobID, workerID, err)
+		}
+	}
+	return s.r.Del(ctx, transport.MakeInFlightKey(workerID), makeOwnedJobsKey(workerID)).Err()
+}
+
+func (s *Scheduler) recoverOrphanedJob(ctx context.Context, jobID, workerID string) error {
+	// claim the attempt so that a late result of it is ignored
+	processing, err := s.removeFromProcessingJobs(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if !processing {
+		return nil
+	}
+	s.releasePushSlot(jobID)
+	if err := s.releaseConstraints(ctx, jobID, s.getConstraints()); err != nil {
+		log.Printf("error releasing constraints of job %s: %v", jobID, err)
+	}
+
+	result := orphanedResult(jobID, workerID, time.Now())
+	cancelRequested, err := s.takeCancelRequest(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if cancelRequested {
+		return s.finishCancelledJob(ctx, jobID, result)
+	}
+
+	log.Printf("retrying job %s of lost worker %s", jobID, workerID)
+	return s.retryJob(ctx, result, s.countOrphanedAttempts)
+}
+
+func orphanedResult(jobID, workerID string, now time.Time) transport.JobResult {
+	return transport.JobResult{
+		JobID:       jobID,
+		Type:        transport.JobResultFailure,
+		FinishedAt:  now.Format(time.RFC3339),
+		Reason:      transport.JobFailureReasonWorkerLost,
+		ShouldRetry: true,
+		Message:     fmt.Sprintf("worker %s was lost while running the job", workerID),
+		WorkerID:    workerID,
+	}
+}
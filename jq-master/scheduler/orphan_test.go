@@ -0,0 +1,192 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+// startAttempt pops the job as if it was dispatched, leaving an in-flight entry for the worker.
+// The worker is recorded as the owner unless workerID is empty.
+func startAttempt(t *testing.T, r *redis.Client, s *scheduler.Scheduler, job scheduler.Job, inFlightOf, workerID string) {
+	t.Helper()
+	ctx := context.Background()
+	cleanupJob(t, r, job.ID)
+	t.Cleanup(func() {
+		r.Del(context.Background(), transport.MakeInFlightKey(inFlightOf), "jq:ownedJobs:"+inFlightOf)
+	})
+
+	if _, err := s.AddJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := popJob(s, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	r.SAdd(ctx, scheduler.RProcessingJobs, job.ID)
+	r.HSet(ctx, "jq:jobState:"+job.ID, "status", scheduler.JobStatusRunning)
+	r.RPush(ctx, transport.MakeInFlightKey(inFlightOf), job.ID)
+	if workerID != "" {
+		r.HSet(ctx, scheduler.RJobOwners, job.ID, workerID)
+		r.SAdd(ctx, "jq:ownedJobs:"+workerID, job.ID)
+	}
+}
+
+// loseWorker registers the worker and removes it again, as when it stops pinging.
+func loseWorker(t *testing.T, s *scheduler.Scheduler, workerID string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.AddWorker(ctx, scheduler.NewWorker(workerID, "worker", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveWorker(ctx, workerID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFinishedAttemptLeavesNoInFlightEntry(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	job := scheduler.Job{ID: "acknowledged-job", Name: "slow", MaxRetry: 1}
+	startAttempt(t, r, s, job, "acknowledging-worker", "acknowledging-worker")
+
+	// the attempt times out; the worker never reports
+	result := transport.JobResult{JobID: job.ID, Type: transport.JobResultFailure, Reason: transport.JobFailureReasonTimeout}
+	if err := s.ProcessResult(ctx, result); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.LLen(ctx, transport.MakeInFlightKey("acknowledging-worker")).Val(); n != 0 {
+		t.Errorf("expected the in-flight entry to be removed, %d left", n)
+	}
+}
+
+func TestStaleInFlightEntryKeepsLiveAttempt(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	// an entry left by an earlier attempt, while the job waits in the global queue again
+	job := scheduler.Job{ID: "redispatched-job", Name: "job", MaxRetry: 1}
+	startAttempt(t, r, s, job, "stale-worker", "")
+	r.RPush(ctx, transport.RGlobalQueue, job.ID)
+
+	loseWorker(t, s, "stale-worker")
+	if !r.SIsMember(ctx, scheduler.RProcessingJobs, job.ID).Val() {
+		t.Error("expected the live attempt to keep running")
+	}
+}
+
+func TestUnownedInFlightEntryIsRecovered(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	// the worker was lost right after taking the job, before recording itself as its owner
+	job := scheduler.Job{ID: "unowned-job", Name: "job", MaxRetry: 1}
+	startAttempt(t, r, s, job, "lost-worker", "")
+
+	loseWorker(t, s, "lost-worker")
+	if r.SIsMember(ctx, scheduler.RProcessingJobs, job.ID).Val() {
+		t.Error("expected the attempt to be given up")
+	}
+	state, err := s.GetJobState(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != scheduler.JobStatusRetrying {
+		t.Errorf("expected the job to be retried, got %s", state.Status)
+	}
+}
+
+func TestOrphanedJobIsRetried(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	job := scheduler.Job{ID: "orphan-retried-job", Name: "job", MaxRetry: 2}
+	startAttempt(t, r, s, job, "orphaning-worker", "orphaning-worker")
+
+	loseWorker(t, s, "orphaning-worker")
+	state, err := s.GetJobState(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != scheduler.JobStatusRetrying || state.RetryCount != 1 {
+		t.Errorf("expected the lost attempt to use up a retry, got %+v", state)
+	}
+	if _, err := r.ZScore(ctx, scheduler.RDelayedJobSet, job.ID).Result(); err != nil {
+		t.Errorf("expected the job to wait for its retry (%v)", err)
+	}
+}
+
+func TestOrphanedAttemptNotCounted(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r), scheduler.WithCountOrphanedAttempts(false))
+
+	// not even a job without retries is given up
+	job := scheduler.Job{ID: "orphan-uncounted-job", Name: "job"}
+	startAttempt(t, r, s, job, "uncounting-worker", "uncounting-worker")
+
+	loseWorker(t, s, "uncounting-worker")
+	state, err := s.GetJobState(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != scheduler.JobStatusRetrying || state.RetryCount != 0 {
+		t.Errorf("expected the job to be retried without using up a retry, got %+v", state)
+	}
+}
+
+func TestOrphanedJobWithoutRetriesIsDeadLettered(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	job := scheduler.Job{ID: "orphan-dead-job", Name: "job"}
+	startAttempt(t, r, s, job, "dead-ending-worker", "dead-ending-worker")
+
+	loseWorker(t, s, "dead-ending-worker")
+	dead, err := s.GetDeadJob(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dead.Result.Reason != transport.JobFailureReasonWorkerLost {
+		t.Errorf("expected the job to die of its lost worker, got %+v", dead.Result)
+	}
+}
+
+func TestInFlightJobIsAdopted(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := scheduler.NewScheduler(r, transport.NewConn(r), scheduler.WithReapInterval(10*time.Millisecond))
+	if err := s.AddWorker(ctx, scheduler.NewWorker("adopting-worker", "worker", 1)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.RemoveWorker(context.Background(), "adopting-worker")
+	})
+
+	// a pulling worker only moves the job into its in-flight list
+	job := scheduler.Job{ID: "adopted-job", Name: "job", MaxRetry: 1}
+	startAttempt(t, r, s, job, "adopting-worker", "")
+
+	go s.ReapTimedOutJobs(ctx, make(chan transport.JobResult))
+	deadline := time.Now().Add(time.Second)
+	for r.HGet(ctx, scheduler.RJobOwners, job.ID).Val() != "adopting-worker" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the worker to be recorded as the owner")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !r.SIsMember(ctx, "jq:ownedJobs:adopting-worker", job.ID).Val() {
+		t.Error("expected the job to be listed for the worker")
+	}
+}
@@ -0,0 +1,194 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+)
+
+const (
+	DefaultPushTimeout       = 10 * time.Second
+	DefaultMaxPushesInFlight = 4 // requests pushing jobs to the same worker at once
+	DefaultMaxPushFailures   = 3 // failed pushes in a row after which a push worker is dropped
+)
+
+// NewPushWorker returns a worker that is sent jobs over HTTP at url instead of pulling them from redis.
+func NewPushWorker(id, workerName string, maxProcesses int, url string) *Worker {
+	w := NewWorker(id, workerName, maxProcesses)
+	w.URL = url
+	return w
+}
+
+// IsPush reports whether jobs are pushed to the worker over HTTP.
+func (w *Worker) IsPush() bool {
+	return w.URL != ""
+}
+
+// reservePushSlot takes a free slot of the push worker with the most free slots for the job,
+// skipping workers that were already tried or have too many pushes in flight.
+// It returns nil if no push worker has a free slot.
+func (s *Scheduler) reservePushSlot(jobID string, tried map[string]bool) *Worker {
+	s.workersMutex.Lock()
+	defer s.workersMutex.Unlock()
+
+	var best *Worker
+	for _, w := range s.workers {
+		if !w.IsPush() || tried[w.ID] || w.running >= w.MaxProcesses || w.pushing >= s.maxPushesInFlight {
+			continue
+		}
+		if best == nil || w.MaxProcesses-w.running > best.MaxProcesses-best.running {
+			best = w
+		}
+	}
+	if best != nil {
+		best.running++
+		best.pushing++
+		s.pushedJobs[jobID] = best
+	}
+	return best
+}
+
+// endPush records the outcome of a push to the worker.
+// It reports whether the worker failed too many pushes in a row to be kept.
+func (s *Scheduler) endPush(w *Worker, err error) bool {
+	s.workersMutex.Lock()
+	defer s.workersMutex.Unlock()
+
+	w.pushing--
+	if err == nil || errors.Is(err, transport.ErrJobRejected) || errors.Is(err, transport.ErrUnknownJobName) {
+		// the worker answered
+		w.pushFailures = 0
+		return false
+	}
+	w.pushFailures++
+	return w.pushFailures >= s.maxPushFailures
+}
+
+// releasePushSlot frees the slot the job took on a push worker, if any.
+func (s *Scheduler) releasePushSlot(jobID string) {
+	s.workersMutex.Lock()
+	defer s.workersMutex.Unlock()
+
+	if w, ok := s.pushedJobs[jobID]; ok {
+		w.running--
+		delete(s.pushedJobs, jobID)
+	}
+}
+
+func (s *Scheduler) hasPullWorkers() bool {
+	s.workersMutex.Lock()
+	defer s.workersMutex.Unlock()
+
+	for _, w := range s.workers {
+		if !w.IsPush() {
+			return true
+		}
+	}
+	return false
+}
+
+// pushJob offers the job to push workers, starting with w whose slot is already reserved,
+// until one accepts it. A job no push worker accepts is handed over to the pulling workers.
+// It runs apart from the dispatch loop, so that a slow worker does not hold up other jobs.
+func (s *Scheduler) pushJob(ctx context.Context, job Job, w *Worker, resultChan chan<- transport.JobResult) {
+	req := transport.WorkerJobRequest{
+		ID:       job.ID,
+		Name:     job.Name,
+		Argument: job.Argument,
+		Timeout:  int(job.EffectiveTimeout() / time.Second),
+	}
+
+	var lastErr error
+	tried := make(map[string]bool)
+	for w != nil {
+		tried[w.ID] = true
+
+		// recorded first, as the worker may report the job done before the push returns
+		if err := s.setJobOwner(ctx, job.ID, w.ID); err != nil {
+			log.Printf("error recording owner of job %s: %v", job.ID, err)
+			s.workersMutex.Lock()
+			w.pushing--
+			s.workersMutex.Unlock()
+			s.releasePushSlot(job.ID)
+			lastErr = err
+			break
+		}
+
+		err := transport.PushJob(ctx, s.httpClient, w.URL, req)
+		drop := s.endPush(w, err)
+		if err == nil {
+			log.Printf("job %s pushed to worker %s", job.ID, w.ID)
+			return
+		}
+
+		s.releasePushSlot(job.ID)
+		if err := s.clearJobOwner(ctx, job.ID, w.ID); err != nil {
+			log.Printf("error clearing owner of job %s: %v", job.ID, err)
+		}
+		if ctx.Err() != nil {
+			// the term is over; the job times out if nobody else reports it
+			return
+		}
+		log.Printf("worker %s did not accept job %s: %v", w.ID, job.ID, err)
+		if drop {
+			log.Printf("dropping push worker %s (%d failed pushes)", w.ID, s.maxPushFailures)
+			if err := s.RemoveWorker(ctx, w.ID); err != nil && !errors.Is(err, ErrWorkerNotFound) {
+				log.Printf("error removing worker %s: %v", w.ID, err)
+			}
+		}
+		lastErr = err
+		if errors.Is(err, transport.ErrJobRejected) {
+			// another worker would not accept an invalid job either
+			break
+		}
+		w = s.reservePushSlot(job.ID, tried)
+	}
+
+	s.handOverUnpushedJob(ctx, job, lastErr, resultChan)
+}
+
+// handOverUnpushedJob dispatches a job that was not pushed: pulling workers get it from the global queue;
+// without them, it is reported as undispatchable if push workers refused it, or put back otherwise.
+// It reports whether the job was put back for lack of workers.
+func (s *Scheduler) handOverUnpushedJob(ctx context.Context, job Job, pushErr error, resultChan chan<- transport.JobResult) bool {
+	if s.hasPullWorkers() {
+		if err := s.tran.DistributeJob(ctx, job.ID); err != nil {
+			log.Printf("error distributing job: %v", err)
+			s.requeueUndispatchedJob(ctx, job)
+		}
+		return false
+	}
+
+	if pushErr == nil {
+		// the push workers filled up in the meantime
+		s.requeueUndispatchedJob(ctx, job)
+		return true
+	}
+	select {
+	case resultChan <- undispatchableResult(job.ID, time.Now(), pushErr):
+	case <-ctx.Done():
+		// the job times out if nobody else reports it
+	}
+	return false
+}
+
+// undispatchableResult is reported for a job no worker accepted.
+func undispatchableResult(jobID string, now time.Time, err error) transport.JobResult {
+	return transport.JobResult{
+		JobID:       jobID,
+		Type:        transport.JobResultFailure,
+		FinishedAt:  now.Format(time.RFC3339),
+		Reason:      transport.JobFailureReasonOther,
+		ShouldRetry: true,
+		Message:     fmt.Sprintf("no worker accepted the job: %v", err),
+	}
+}
+
+// IsProcessing reports whether an attempt of the job is in flight.
+func (s *Scheduler) IsProcessing(ctx context.Context, jobID string) (bool, error) {
+	return s.r.SIsMember(ctx, RProcessingJobs, jobID).Result()
+}
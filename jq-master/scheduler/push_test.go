@@ -0,0 +1,158 @@
+package scheduler_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// addPushWorker registers a push worker sending its requests to handler.
+func addPushWorker(t *testing.T, s *scheduler.Scheduler, id string, maxProcesses int, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	if err := s.AddWorker(context.Background(), scheduler.NewPushWorker(id, "worker", maxProcesses, srv.URL)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.RemoveWorker(context.Background(), id)
+	})
+}
+
+func TestSlowPushDoesNotHoldUpDispatch(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	s := scheduler.NewScheduler(r, transport.NewConn(r), scheduler.WithMaxPushesInFlight(2))
+
+	arrived := make(chan string, 10)
+	release := make(chan struct{})
+	addPushWorker(t, s, "slow-push-worker", 3, func(w http.ResponseWriter, req *http.Request) {
+		var job transport.WorkerJobRequest
+		if err := msgpack.NewDecoder(req.Body).Decode(&job); err != nil {
+			t.Error(err)
+		}
+		arrived <- job.ID
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		job := scheduler.Job{ID: fmt.Sprintf("slow-push-job-%d", i), Name: "job"}
+		cleanupJob(t, r, job.ID)
+		if _, err := s.AddJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go s.DistributeJobs(ctx, make(chan transport.JobResult))
+
+	// the second push does not wait for the first to be answered
+	for i := 0; i < 2; i++ {
+		select {
+		case <-arrived:
+		case <-ctx.Done():
+			t.Fatalf("only %d pushes in flight", i)
+		}
+	}
+	// but no more than two are in flight at once
+	select {
+	case jobID := <-arrived:
+		t.Fatalf("job %s pushed while two pushes were in flight", jobID)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-arrived:
+	case <-ctx.Done():
+		t.Fatal("the last job was never pushed")
+	}
+}
+
+func TestFailingPushWorkerIsDropped(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	s := scheduler.NewScheduler(r, transport.NewConn(r), scheduler.WithMaxPushFailures(2))
+
+	addPushWorker(t, s, "failing-push-worker", 2, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		job := scheduler.Job{ID: fmt.Sprintf("failing-push-job-%d", i), Name: "job"}
+		cleanupJob(t, r, job.ID)
+		if _, err := s.AddJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resultChan := make(chan transport.JobResult)
+	go s.DistributeJobs(ctx, resultChan)
+
+	// no other worker could take the jobs
+	for i := 0; i < 2; i++ {
+		select {
+		case <-resultChan:
+		case <-ctx.Done():
+			t.Fatal("the failed pushes were never reported")
+		}
+	}
+	if s.HasWorker("failing-push-worker") {
+		t.Error("expected the worker to be dropped after failing twice in a row")
+	}
+	for i := 0; i < 2; i++ {
+		if r.HExists(ctx, scheduler.RJobOwners, fmt.Sprintf("failing-push-job-%d", i)).Val() {
+			t.Errorf("expected the worker not to be recorded as the owner of job %d", i)
+		}
+	}
+}
+
+func TestFastPushWorkerLeavesNoOwner(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	job := scheduler.Job{ID: "fast-push-job", Name: "job"}
+	cleanupJob(t, r, job.ID)
+	accepted := make(chan struct{})
+	addPushWorker(t, s, "fast-push-worker", 1, func(w http.ResponseWriter, req *http.Request) {
+		// the job is done before the push is answered
+		result := transport.JobResult{JobID: job.ID, Type: transport.JobResultSuccess, WorkerID: "fast-push-worker"}
+		if err := s.ProcessResult(req.Context(), result); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		close(accepted)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.AddJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	go s.DistributeJobs(ctx, make(chan transport.JobResult))
+
+	select {
+	case <-accepted:
+	case <-ctx.Done():
+		t.Fatal("the job was never pushed")
+	}
+	// let the push return
+	time.Sleep(100 * time.Millisecond)
+	if owner := r.HGet(ctx, scheduler.RJobOwners, job.ID).Val(); owner != "" {
+		t.Errorf("expected the finished job to have no owner, got %s", owner)
+	}
+	if r.SIsMember(ctx, "jq:ownedJobs:fast-push-worker", job.ID).Val() {
+		t.Error("expected the finished job not to be listed for the worker")
+	}
+}
@@ -0,0 +1,277 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lightpub-dev/lightjq/jq-master/cron"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	RRecurringJobs    = "jq:recurringJobs"    // definition id -> RecurringJob
+	RRecurringNextRun = "jq:recurringNextRun" // definition id -> next tick (unix millis); paused definitions are absent
+
+	DefaultRecurringInterval = 1 * time.Second
+)
+
+var ErrRecurringJobNotFound = errors.New("recurring job not found")
+
+// advances the next tick of a definition, unless someone else already did
+// KEYS[1]: next runs
+// ARGV[1]: definition id, ARGV[2]: tick being claimed, ARGV[3]: following tick
+var claimTickScript = redis.NewScript(`
+local current = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if current and tonumber(current) == tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// RecurringJob is a job definition enqueued by jq-master on a cron schedule.
+type RecurringJob struct {
+	ID         string                 `msgpack:"id"`       // unique definition id
+	Schedule   string                 `msgpack:"schedule"` // cron expression
+	Timezone   string                 `msgpack:"timezone"` // IANA name; empty for UTC
+	Name       string                 `msgpack:"name"`     // name of the enqueued jobs
+	Argument   map[string]interface{} `msgpack:"argument"`
+	Priority   int                    `msgpack:"priority"`
+	MaxRetry   int                    `msgpack:"max_retry"`
+	KeepResult bool                   `msgpack:"keep_result"`
+	Timeout    time.Duration          `msgpack:"timeout"`
+	Paused     bool                   `msgpack:"paused"`
+}
+
+// RecurringJobInfo is a definition together with its next tick (zero while paused).
+type RecurringJobInfo struct {
+	RecurringJob
+	NextRun time.Time
+}
+
+// next returns the first tick of the definition after t.
+func (rj RecurringJob) next(t time.Time) (time.Time, error) {
+	schedule, err := cron.Parse(rj.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(rj.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone: %w", err)
+	}
+	next := schedule.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("schedule %q never fires", rj.Schedule)
+	}
+	return next, nil
+}
+
+// newJob builds the job enqueued for the definition.
+func (rj RecurringJob) newJob(jobID string, now time.Time) Job {
+	return Job{
+		ID:           jobID,
+		Name:         rj.Name,
+		Argument:     rj.Argument,
+		Priority:     rj.Priority,
+		MaxRetry:     rj.MaxRetry,
+		KeepResult:   rj.KeepResult,
+		Timeout:      rj.Timeout,
+		RegisteredAt: now,
+	}
+}
+
+func (s *Scheduler) getRecurringJob(ctx context.Context, id string) (RecurringJob, error) {
+	rjBin, err := s.r.HGet(ctx, RRecurringJobs, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return RecurringJob{}, ErrRecurringJobNotFound
+	}
+	if err != nil {
+		return RecurringJob{}, err
+	}
+
+	var rj RecurringJob
+	if err := msgpack.Unmarshal(rjBin, &rj); err != nil {
+		return RecurringJob{}, err
+	}
+	return rj, nil
+}
+
+// saveRecurringJob stores the definition and schedules its next tick after now, unless it is paused.
+func (s *Scheduler) saveRecurringJob(ctx context.Context, rj RecurringJob, now time.Time) error {
+	next, err := rj.next(now)
+	if err != nil {
+		return err
+	}
+	rjBin, err := msgpack.Marshal(&rj)
+	if err != nil {
+		return err
+	}
+
+	tx := s.r.TxPipeline()
+	tx.HSet(ctx, RRecurringJobs, rj.ID, rjBin)
+	if rj.Paused {
+		tx.ZRem(ctx, RRecurringNextRun, rj.ID)
+	} else {
+		tx.ZAdd(ctx, RRecurringNextRun, redis.Z{
+			Score:  float64(next.UnixMilli()),
+			Member: rj.ID,
+		})
+	}
+	_, err = tx.Exec(ctx)
+	return err
+}
+
+// AddRecurringJob registers or replaces a recurring job definition.
+func (s *Scheduler) AddRecurringJob(ctx context.Context, rj RecurringJob) error {
+	if rj.ID == "" || rj.Name == "" {
+		return fmt.Errorf("recurring job requires an id and a job name")
+	}
+	return s.saveRecurringJob(ctx, rj, time.Now())
+}
+
+// RemoveRecurringJob deletes a recurring job definition. Jobs already enqueued are not affected.
+func (s *Scheduler) RemoveRecurringJob(ctx context.Context, id string) error {
+	tx := s.r.TxPipeline()
+	hdel := tx.HDel(ctx, RRecurringJobs, id)
+	tx.ZRem(ctx, RRecurringNextRun, id)
+	if _, err := tx.Exec(ctx); err != nil {
+		return err
+	}
+	if hdel.Val() == 0 {
+		return ErrRecurringJobNotFound
+	}
+	return nil
+}
+
+// ListRecurringJobs returns every recurring job definition.
+func (s *Scheduler) ListRecurringJobs(ctx context.Context) ([]RecurringJobInfo, error) {
+	pipe := s.r.Pipeline()
+	defs := pipe.HGetAll(ctx, RRecurringJobs)
+	nextRuns := pipe.ZRangeWithScores(ctx, RRecurringNextRun, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	nextRunByID := make(map[string]time.Time, len(nextRuns.Val()))
+	for _, z := range nextRuns.Val() {
+		nextRunByID[z.Member.(string)] = time.UnixMilli(int64(z.Score))
+	}
+
+	infos := make([]RecurringJobInfo, 0, len(defs.Val()))
+	for id, rjBin := range defs.Val() {
+		var rj RecurringJob
+		if err := msgpack.Unmarshal([]byte(rjBin), &rj); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal recurring job %s: %w", id, err)
+		}
+		infos = append(infos, RecurringJobInfo{
+			RecurringJob: rj,
+			NextRun:      nextRunByID[id],
+		})
+	}
+	return infos, nil
+}
+
+// PauseRecurringJob stops enqueueing jobs for the definition until it is resumed.
+func (s *Scheduler) PauseRecurringJob(ctx context.Context, id string) error {
+	return s.setRecurringJobPaused(ctx, id, true)
+}
+
+// ResumeRecurringJob restarts a paused definition from its next tick; ticks missed while paused are skipped.
+func (s *Scheduler) ResumeRecurringJob(ctx context.Context, id string) error {
+	return s.setRecurringJobPaused(ctx, id, false)
+}
+
+func (s *Scheduler) setRecurringJobPaused(ctx context.Context, id string, paused bool) error {
+	rj, err := s.getRecurringJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	rj.Paused = paused
+	return s.saveRecurringJob(ctx, rj, time.Now())
+}
+
+// TriggerRecurringJob enqueues a job for the definition right away, outside of its schedule.
+// It returns the id of the enqueued job.
+func (s *Scheduler) TriggerRecurringJob(ctx context.Context, id string) (string, error) {
+	rj, err := s.getRecurringJob(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	return s.AddJob(ctx, rj.newJob(uuid.NewString(), time.Now()))
+}
+
+// RunRecurringJobs periodically enqueues jobs of recurring definitions whose tick has come.
+// Each tick is enqueued under an id derived from it before the tick is advanced, so it is
+// enqueued exactly once even across restarts or with several masters. Ticks missed while jq-master was down are coalesced into one job.
+func (s *Scheduler) RunRecurringJobs(ctx context.Context) {
+	ticker := time.NewTicker(s.recurringInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.enqueueDueRecurringJobs(ctx); err != nil {
+				log.Printf("error enqueueing recurring jobs: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) enqueueDueRecurringJobs(ctx context.Context) error {
+	now := time.Now()
+	due, err := s.r.ZRangeByScoreWithScores(ctx, RRecurringNextRun, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, z := range due {
+		id := z.Member.(string)
+		tick := int64(z.Score)
+
+		rj, err := s.getRecurringJob(ctx, id)
+		if errors.Is(err, ErrRecurringJobNotFound) {
+			s.r.ZRem(ctx, RRecurringNextRun, id)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		next, err := rj.next(now)
+		if err != nil {
+			log.Printf("invalid recurring job %s: %v", id, err)
+			continue
+		}
+
+		// the job id is derived from the tick, so the same tick always maps to the same job;
+		// it is enqueued before the tick is advanced, so a master stopping in between
+		// leaves the tick to the next one, which finds the job and does not enqueue it again
+		jobID := fmt.Sprintf("%s@%d", id, tick)
+		enqueued, err := s.enqueueJobOnce(ctx, rj.newJob(jobID, now))
+		if err != nil {
+			log.Printf("error enqueueing recurring job %s: %v", jobID, err)
+			continue
+		}
+
+		if err := claimTickScript.Run(ctx, s.r, []string{RRecurringNextRun}, id, tick, next.UnixMilli()).Err(); err != nil {
+			return err
+		}
+		if enqueued {
+			log.Printf("recurring job %s enqueued", jobID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package scheduler_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRecurringTickEnqueuedOnce(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r), scheduler.WithRecurringInterval(10*time.Millisecond))
+
+	rj := scheduler.RecurringJob{ID: "once-" + t.Name(), Schedule: "0 0 1 1 *", Name: "recurring"}
+	if err := s.AddRecurringJob(ctx, rj); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.RemoveRecurringJob(context.Background(), rj.ID)
+	})
+
+	// a previous master enqueued the tick, which already ran, but stopped before advancing it
+	tick := time.Now().Add(-time.Minute).UnixMilli()
+	jobID := fmt.Sprintf("%s@%d", rj.ID, tick)
+	cleanupJob(t, r, jobID)
+	if _, err := s.AddJob(ctx, scheduler.Job{ID: jobID, Name: rj.Name}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := popJob(s, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	finishJob(t, r, s, jobID)
+	if err := r.ZAdd(ctx, scheduler.RRecurringNextRun, redis.Z{Score: float64(tick), Member: rj.ID}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	go s.RunRecurringJobs(runCtx)
+	for {
+		next, err := r.ZScore(ctx, scheduler.RRecurringNextRun, rj.ID).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(next) != tick {
+			break
+		}
+		if runCtx.Err() != nil {
+			t.Fatal("the tick was never advanced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if queued, err := r.ZScore(ctx, scheduler.RScoredJobSet, jobID).Result(); err != redis.Nil {
+		t.Errorf("expected the tick not to be enqueued again, got score %v (%v)", queued, err)
+	}
+}
@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const RWorkers = "jq:workers" // worker id -> registered worker
+
+// saveWorker persists the worker so that it is known again after a restart.
+func (s *Scheduler) saveWorker(ctx context.Context, w *Worker) error {
+	workerBin, err := msgpack.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return s.r.HSet(ctx, RWorkers, w.ID, workerBin).Err()
+}
+
+func (s *Scheduler) deleteWorker(ctx context.Context, workerID string) error {
+	return s.r.HDel(ctx, RWorkers, workerID).Err()
+}
+
+// loadWorker returns the persisted worker, or nil if it is not registered.
+func (s *Scheduler) loadWorker(ctx context.Context, workerID string) (*Worker, error) {
+	workerBin, err := s.r.HGet(ctx, RWorkers, workerID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w Worker
+	if err := msgpack.Unmarshal(workerBin, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// loadWorkers returns the persisted workers.
+func (s *Scheduler) loadWorkers(ctx context.Context) ([]*Worker, error) {
+	workerBins, err := s.r.HGetAll(ctx, RWorkers).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	workers := make([]*Worker, 0, len(workerBins))
+	for workerID, workerBin := range workerBins {
+		var w Worker
+		if err := msgpack.Unmarshal([]byte(workerBin), &w); err != nil {
+			log.Printf("dropping invalid worker %s: %v", workerID, err)
+			if err := s.deleteWorker(ctx, workerID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		workers = append(workers, &w)
+	}
+	return workers, nil
+}
+
+// Recover rebuilds the scheduler after a restart: it restores the persisted workers
+// and retries in-flight jobs whose workers are no longer registered.
+// It returns the restored workers; their liveness is not known yet.
+func (s *Scheduler) Recover(ctx context.Context) ([]*Worker, error) {
+	workers, err := s.loadWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.workersMutex.Lock()
+	for _, w := range workers {
+		// workers registered in the meantime are already known
+		known := false
+		for _, worker := range s.workers {
+			known = known || worker.ID == w.ID
+		}
+		if !known {
+			s.workers = append(s.workers, w)
+			s.maxProcesses += w.MaxProcesses
+		}
+	}
+	s.workersMutex.Unlock()
+
+	if err := s.reconcileProcessingJobs(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.reconcileInFlightLists(ctx); err != nil {
+		return nil, err
+	}
+
+	log.Printf("restored %d workers", len(workers))
+	return workers, nil
+}
+
+// reconcileProcessingJobs matches in-flight jobs with the registered workers.
+// Jobs still waiting in the global queue or not claimed by any worker yet are left to time out normally.
+func (s *Scheduler) reconcileProcessingJobs(ctx context.Context) error {
+	jobIDs, err := s.r.SMembers(ctx, RProcessingJobs).Result()
+	if err != nil {
+		return err
+	}
+	queuedIDs, err := s.r.LRange(ctx, transport.RGlobalQueue, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	queued := make(map[string]bool, len(queuedIDs))
+	for _, jobID := range queuedIDs {
+		queued[jobID] = true
+	}
+
+	for _, jobID := range jobIDs {
+		if queued[jobID] {
+			continue
+		}
+
+		owner, err := s.getJobOwner(ctx, jobID)
+		if err != nil {
+			return err
+		}
+		if owner == "" {
+			continue
+		}
+
+		if s.restorePushSlot(jobID, owner) || s.HasWorker(owner) {
+			continue
+		}
+		if err := s.recoverOrphanedJob(ctx, jobID, owner); err != nil {
+			log.Printf("error recovering job %s of worker %s: %v", jobID, owner, err)
+		}
+	}
+	return nil
+}
+
+// restorePushSlot marks the job as taking a slot of its push worker.
+// It reports false if the owner is not a registered push worker.
+// Recover runs on every election, so a slot already taken by the job is not taken twice.
+func (s *Scheduler) restorePushSlot(jobID, workerID string) bool {
+	s.workersMutex.Lock()
+	defer s.workersMutex.Unlock()
+
+	if w, ok := s.pushedJobs[jobID]; ok && w.ID == workerID {
+		return true
+	}
+	for _, w := range s.workers {
+		if w.ID == workerID && w.IsPush() {
+			if previous, ok := s.pushedJobs[jobID]; ok {
+				previous.running--
+			}
+			w.running++
+			s.pushedJobs[jobID] = w
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileInFlightLists recovers the jobs left unacknowledged by workers that are no longer registered.
+func (s *Scheduler) reconcileInFlightLists(ctx context.Context) error {
+	iter := s.r.Scan(ctx, 0, transport.MakeInFlightKey("*"), 0).Iterator()
+	for iter.Next(ctx) {
+		workerID := strings.TrimPrefix(iter.Val(), transport.MakeInFlightKey(""))
+		if s.HasWorker(workerID) {
+			continue
+		}
+		if err := s.recoverOrphanedJobs(ctx, workerID); err != nil {
+			log.Printf("error recovering jobs of worker %s: %v", workerID, err)
+		}
+	}
+	return iter.Err()
+}
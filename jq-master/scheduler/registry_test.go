@@ -0,0 +1,73 @@
+package scheduler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestRecoverTwiceKeepsPushSlots(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pushed := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var job transport.WorkerJobRequest
+		if err := msgpack.NewDecoder(req.Body).Decode(&job); err != nil {
+			t.Error(err)
+		}
+		pushed <- job.ID
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	// a previous master pushed a job to a worker with a single slot
+	worker := scheduler.NewPushWorker("recover-push-worker", "worker", 1, srv.URL)
+	if err := scheduler.NewScheduler(r, transport.NewConn(r)).AddWorker(ctx, worker); err != nil {
+		t.Fatal(err)
+	}
+	running := scheduler.Job{ID: "recover-running-job", Name: "job"}
+	cleanupJob(t, r, running.ID)
+	r.SAdd(ctx, scheduler.RProcessingJobs, running.ID)
+	r.HSet(ctx, scheduler.RJobOwners, running.ID, worker.ID)
+	r.SAdd(ctx, "jq:ownedJobs:"+worker.ID, running.ID)
+
+	// this master is elected twice
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+	t.Cleanup(func() {
+		s.RemoveWorker(context.Background(), worker.ID)
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := s.Recover(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// finishing the job frees the only slot again
+	if err := s.ProcessResult(ctx, transport.JobResult{JobID: running.ID, Type: transport.JobResultSuccess, WorkerID: worker.ID}); err != nil {
+		t.Fatal(err)
+	}
+	next := scheduler.Job{ID: "recover-next-job", Name: "job"}
+	cleanupJob(t, r, next.ID)
+	if _, err := s.AddJob(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+	go s.DistributeJobs(ctx, make(chan transport.JobResult))
+
+	select {
+	case jobID := <-pushed:
+		if jobID != next.ID {
+			t.Errorf("unexpected job pushed: %s", jobID)
+		}
+	case <-ctx.Done():
+		t.Error("the worker never got the next job; its slot is still counted")
+	}
+}
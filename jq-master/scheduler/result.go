@@ -2,62 +2,169 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
 func (s *Scheduler) ProcessResult(ctx context.Context, result transport.JobResult) error {
+	if err := s.checkFence(ctx); err != nil {
+		return s.handBackResult(ctx, result, err)
+	}
+
+	if result.WorkerID != "" {
+		owner, err := s.getJobOwner(ctx, result.JobID)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != result.WorkerID {
+			// the attempt of that worker has been given up and the job runs elsewhere now
+			log.Printf("ignoring result of job %s from worker %s; it is run by worker %s", result.JobID, result.WorkerID, owner)
+			return nil
+		}
+	}
+
+	// one worker is now available
+	processing, err := s.removeFromProcessingJobs(ctx, result.JobID)
+	if errors.Is(err, ErrFenced) {
+		// the term ended since the check above
+		return s.handBackResult(ctx, result, err)
+	}
+	if err != nil {
+		return err
+	}
+	if !processing {
+		// the attempt has already been finished (e.g. it timed out before the worker reported)
+		log.Printf("ignoring stale result of job %s", result.JobID)
+		return nil
+	}
+	s.releasePushSlot(result.JobID)
+	if err := s.releaseConstraints(ctx, result.JobID, s.getConstraints()); err != nil {
+		return err
+	}
+	cancelRequested, err := s.takeCancelRequest(ctx, result.JobID)
+	if err != nil {
+		return err
+	}
+	if cancelRequested && result.Type == transport.JobResultFailure {
+		// the worker stopped the job because it was cancelled; do not retry it
+		return s.finishCancelledJob(ctx, result.JobID, result)
+	}
+
 	switch result.Type {
 	case transport.JobResultSuccess:
+		job, err := s.getJob(ctx, result.JobID)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		job.ID = result.JobID
+		fields, err := resultStateFields(result)
+		if err != nil {
+			return err
+		}
+		// kept for jobs added later that depend on this one
+		resultBin, err := msgpack.Marshal(result.Result)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, "result", resultBin)
+		tx := s.r.TxPipeline()
+		s.recordJobState(ctx, tx, job.ID, JobStatusDone, fields...)
 		// remove job data
-		jobKey := makeJobKey(result.JobID)
-		if err := s.r.Del(ctx, jobKey).Err(); err != nil {
+		tx.Del(ctx, makeJobKey(job.ID))
+		if _, err := tx.Exec(ctx); err != nil {
 			return err
 		}
-		// one worker is now available
-		s.removeFromProcessingJobs(ctx, result.JobID)
+		// duplicates may be enqueued again
+		if err := s.releaseUniqueKey(ctx, job); err != nil {
+			log.Printf("error releasing unique key of job %s: %v", job.ID, err)
+		}
+		// run jobs that were waiting for this one
+		if err := s.releaseChildren(ctx, result); err != nil {
+			log.Printf("error releasing children of job %s: %v", result.JobID, err)
+		}
 		// send back the result to pusher
-		return s.tran.PublishResult(ctx, result)
+		return s.publishFinalResult(ctx, job, result)
 	case transport.JobResultFailure:
-		return s.retryJob(ctx, result)
+		return s.retryJob(ctx, result, true)
 	default:
 		return fmt.Errorf("unknown job result type: %s", result.Type)
 	}
 }
 
-func (s *Scheduler) retryJob(ctx context.Context, result transport.JobResult) error {
-	jobKey := makeJobKey(result.JobID)
-	jobBin, err := s.r.Get(ctx, jobKey).Bytes()
-	if err != nil {
-		return err
+// handBackResult leaves a result to the master leading now.
+func (s *Scheduler) handBackResult(ctx context.Context, result transport.JobResult, err error) error {
+	if submitErr := s.tran.SubmitResult(ctx, result); submitErr != nil {
+		log.Printf("error handing back result of job %s: %v", result.JobID, submitErr)
 	}
+	return err
+}
 
-	var job Job
-	if err := msgpack.Unmarshal(jobBin, &job); err != nil {
+// retryJob enqueues a failed job again, or dead-letters it if it has no retries left.
+// An attempt that is not counted is retried right away without using up a retry.
+func (s *Scheduler) retryJob(ctx context.Context, result transport.JobResult, countAttempt bool) error {
+	job, err := s.getJob(ctx, result.JobID)
+	if err != nil {
 		return err
 	}
 
-	if job.MaxRetry == job.CurrentRetry {
+	if countAttempt && job.MaxRetry == job.CurrentRetry {
 		// no more retry left
 
-		// remove job data
-		if err := s.r.Del(ctx, jobKey).Err(); err != nil {
+		// keep the job for inspection
+		if err := s.addDeadJob(ctx, job, result); err != nil {
 			return err
 		}
-		// one worker is now available
-		s.removeFromProcessingJobs(ctx, result.JobID)
+		// duplicates may be enqueued again
+		if err := s.releaseUniqueKey(ctx, job); err != nil {
+			log.Printf("error releasing unique key of job %s: %v", job.ID, err)
+		}
+		// jobs waiting for this one can never run
+		if err := s.failChildren(ctx, job.ID); err != nil {
+			log.Printf("error failing children of job %s: %v", job.ID, err)
+		}
 		// report error to pusher
-		return s.tran.PublishResult(ctx, result)
+		return s.publishFinalResult(ctx, job, result)
 	}
 
-	job.CurrentRetry++
+	readyAt := time.Now()
+	if countAttempt {
+		job.CurrentRetry++
+		// re-enqueue the job once its backoff has elapsed
+		readyAt = readyAt.Add(s.backoff.Delay(job.CurrentRetry))
+	}
 
-	// re-enqueue the job
-	if err := s.AddJob(ctx, job); err != nil {
+	fields, err := resultStateFields(result)
+	if err != nil {
 		return err
 	}
+	// recorded first so that a quick promotion is not overtaken
+	fields = append(fields, "retry_count", job.CurrentRetry)
+	if err := s.recordJobState(ctx, s.r, job.ID, JobStatusRetrying, fields...).Err(); err != nil {
+		log.Printf("error recording state of job %s: %v", job.ID, err)
+	}
+
+	return s.addDelayedJob(ctx, job, readyAt)
+}
+
+// publishFinalResult reports the outcome of a job that will not run again,
+// keeping it for retrieval if the job asked for it.
+func (s *Scheduler) publishFinalResult(ctx context.Context, job Job, result transport.JobResult) error {
+	if job.KeepResult {
+		if err := s.tran.StoreResult(ctx, result, s.resultTTL); err != nil {
+			log.Printf("error storing result of job %s: %v", result.JobID, err)
+		}
+	}
+	return s.tran.PublishResult(ctx, result)
+}
 
-	return nil
+// TakeJobResult returns the result kept for a job and discards it.
+// It returns nil if the job did not keep its result, or the result expired or was already taken.
+func (s *Scheduler) TakeJobResult(ctx context.Context, jobID string) (*transport.JobResult, error) {
+	return s.tran.TakeResult(ctx, jobID)
 }
@@ -0,0 +1,93 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+// runJob runs the job once and reports result for it.
+func runJob(t *testing.T, r *redis.Client, s *scheduler.Scheduler, job scheduler.Job, result transport.JobResult) {
+	t.Helper()
+	ctx := context.Background()
+	cleanupJob(t, r, job.ID)
+	if _, err := s.AddJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	popped, err := popJob(s, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if popped.ID != job.ID {
+		t.Fatalf("expected job %s to be popped, got %s", job.ID, popped.ID)
+	}
+	// as recorded when the job is dispatched
+	r.SAdd(ctx, scheduler.RProcessingJobs, job.ID)
+	r.HSet(ctx, "jq:jobState:"+job.ID, "status", scheduler.JobStatusRunning)
+	if err := s.ProcessResult(ctx, result); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeptResultIsTakenOnce(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r), scheduler.WithResultTTL(time.Minute))
+
+	job := scheduler.Job{ID: "kept-result-job", Name: "job", KeepResult: true}
+	runJob(t, r, s, job, transport.JobResult{JobID: job.ID, Type: transport.JobResultSuccess, Result: "output"})
+
+	ttl, err := r.PTTL(ctx, transport.MakeJobResultKey(job.ID)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the result to expire within the result ttl, got %v", ttl)
+	}
+
+	result, err := s.TakeJobResult(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.Result != "output" {
+		t.Fatalf("expected the kept result, got %+v", result)
+	}
+	if result, err := s.TakeJobResult(ctx, job.ID); err != nil || result != nil {
+		t.Errorf("expected the result to be taken only once, got %+v (%v)", result, err)
+	}
+}
+
+func TestKeptFailureOfDeadJob(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	job := scheduler.Job{ID: "kept-failure-job", Name: "job", KeepResult: true}
+	runJob(t, r, s, job, transport.JobResult{JobID: job.ID, Type: transport.JobResultFailure, Reason: transport.JobFailureReasonOther, Message: "broken"})
+
+	result, err := s.TakeJobResult(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.Type != transport.JobResultFailure || result.Message != "broken" {
+		t.Errorf("expected the final failure to be kept, got %+v", result)
+	}
+}
+
+func TestResultNotKeptByDefault(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+
+	job := scheduler.Job{ID: "unkept-result-job", Name: "job"}
+	runJob(t, r, s, job, transport.JobResult{JobID: job.ID, Type: transport.JobResultSuccess, Result: "output"})
+
+	if result, err := s.TakeJobResult(ctx, job.ID); err != nil || result != nil {
+		t.Errorf("expected no kept result, got %+v (%v)", result, err)
+	}
+}
@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	JobStatusScheduled = "scheduled" // waiting for its run_at time
+	JobStatusWaiting   = "waiting"   // waiting for its parents to succeed
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusRetrying  = "retrying" // failed and waiting for its backoff to elapse
+	JobStatusError     = "error"    // dead-lettered
+	JobStatusDone      = "done"
+	JobStatusCancelled = "cancelled"
+)
+
+const (
+	DefaultJobStateTTL = 24 * time.Hour
+	DefaultResultTTL   = 10 * time.Minute // how long a kept result waits to be taken
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+func makeJobStateKey(jobID string) string {
+	return "jq:jobState:" + jobID
+}
+
+// jobStateTransitions lists, for every status, the statuses a job may move to it from.
+// "" stands for a job that has no state yet.
+// A dispatcher may pick up a job before its promotion is recorded, so running may follow scheduled or retrying directly.
+var jobStateTransitions = map[string][]string{
+	JobStatusScheduled: {"", JobStatusWaiting},
+	JobStatusWaiting:   {""},
+	JobStatusQueued:    {"", JobStatusScheduled, JobStatusWaiting, JobStatusQueued, JobStatusRetrying},
+	JobStatusRunning:   {JobStatusQueued, JobStatusScheduled, JobStatusRetrying},
+	JobStatusRetrying:  {JobStatusRunning},
+	JobStatusError:     {JobStatusRunning, JobStatusWaiting},
+	JobStatusDone:      {JobStatusRunning},
+	JobStatusCancelled: {JobStatusScheduled, JobStatusWaiting, JobStatusQueued, JobStatusRunning, JobStatusRetrying},
+}
+
+// CanTransitionJobStatus reports whether a job may move from one status to another.
+// Use "" as from for a job that has no state yet.
+func CanTransitionJobStatus(from, to string) bool {
+	for _, allowed := range jobStateTransitions[to] {
+		if allowed == from {
+			return true
+		}
+	}
+	return false
+}
+
+// KEYS[1]: job state key
+// ARGV[1]: new status, ARGV[2]: ttl in millis (0 keeps the state forever), ARGV[3]: now (unix millis),
+// ARGV[4]: "1" to skip the transition check, ARGV[5]: number of allowed previous statuses n,
+// ARGV[6..5+n]: allowed previous statuses, rest: field/value pairs to store
+// returns 1 if the state was updated, 0 if the transition was rejected
+var jobStateScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], "status") or ""
+local n = tonumber(ARGV[5])
+if ARGV[4] ~= "1" then
+	local allowed = false
+	for i = 6, 5 + n do
+		if ARGV[i] == current then
+			allowed = true
+			break
+		end
+	end
+	if not allowed then
+		return 0
+	end
+end
+redis.call("HSET", KEYS[1], "status", ARGV[1], "updated_at", ARGV[3])
+for i = 6 + n, #ARGV, 2 do
+	redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+else
+	redis.call("PERSIST", KEYS[1])
+end
+return 1
+`)
+
+// JobState is the recorded lifecycle state of a job.
+type JobState struct {
+	Status     string
+	WorkerID   string      // worker which has executed the job last
+	RetryCount int         // number of times the job has been retried
+	Error      interface{} // last error reported for the job
+	Message    string      // last message reported for the job
+	UpdatedAt  time.Time
+}
+
+// recordJobState moves the job to status, storing the given field/value pairs alongside it.
+// Transitions that would move the job backwards are rejected; the returned command yields 0 then.
+func (s *Scheduler) recordJobState(ctx context.Context, c redis.Scripter, jobID, status string, fields ...interface{}) *redis.Cmd {
+	return s.runJobStateScript(ctx, c, jobID, status, false, fields)
+}
+
+// forceJobState moves the job to status regardless of its current one.
+func (s *Scheduler) forceJobState(ctx context.Context, c redis.Scripter, jobID, status string, fields ...interface{}) *redis.Cmd {
+	return s.runJobStateScript(ctx, c, jobID, status, true, fields)
+}
+
+func (s *Scheduler) runJobStateScript(ctx context.Context, c redis.Scripter, jobID, status string, force bool, fields []interface{}) *redis.Cmd {
+	var ttl time.Duration
+	if status == JobStatusDone || status == JobStatusCancelled {
+		// finished jobs are only kept for a while; dead jobs stay until they are purged
+		ttl = s.stateTTL
+	}
+	forceArg := "0"
+	if force {
+		forceArg = "1"
+	}
+
+	allowed := jobStateTransitions[status]
+	args := make([]interface{}, 0, 5+len(allowed)+len(fields))
+	args = append(args, status, ttl.Milliseconds(), time.Now().UnixMilli(), forceArg, len(allowed))
+	for _, from := range allowed {
+		args = append(args, from)
+	}
+	args = append(args, fields...)
+
+	keys := []string{makeJobStateKey(jobID)}
+	if _, ok := c.(redis.Pipeliner); ok {
+		// EVALSHA cannot fall back to EVAL inside a pipeline
+		return jobStateScript.Eval(ctx, c, keys, args...)
+	}
+	return jobStateScript.Run(ctx, c, keys, args...)
+}
+
+// resultStateFields returns the state fields describing a result reported for a job.
+func resultStateFields(result transport.JobResult) ([]interface{}, error) {
+	fields := []interface{}{"message", result.Message}
+	if result.WorkerID != "" {
+		fields = append(fields, "worker_id", result.WorkerID)
+	}
+	if result.Error != nil {
+		errBin, err := msgpack.Marshal(result.Error)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, "error", errBin)
+	}
+	return fields, nil
+}
+
+// GetJobState returns the recorded state of a job.
+// Finished jobs are forgotten once their state expires.
+func (s *Scheduler) GetJobState(ctx context.Context, jobID string) (JobState, error) {
+	fields, err := s.r.HGetAll(ctx, makeJobStateKey(jobID)).Result()
+	if err != nil {
+		return JobState{}, err
+	}
+	if len(fields) == 0 {
+		return JobState{}, ErrJobNotFound
+	}
+
+	state := JobState{
+		Status:   fields["status"],
+		WorkerID: fields["worker_id"],
+		Message:  fields["message"],
+	}
+	if v, ok := fields["retry_count"]; ok {
+		if state.RetryCount, err = strconv.Atoi(v); err != nil {
+			return JobState{}, err
+		}
+	}
+	if v, ok := fields["updated_at"]; ok {
+		updatedAt, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return JobState{}, err
+		}
+		state.UpdatedAt = time.UnixMilli(updatedAt)
+	}
+	if v, ok := fields["error"]; ok {
+		if err := msgpack.Unmarshal([]byte(v), &state.Error); err != nil {
+			return JobState{}, err
+		}
+	}
+	return state, nil
+}
+
+// JobStatus reports where the job currently is in its lifecycle.
+func (s *Scheduler) JobStatus(ctx context.Context, jobID string) (string, error) {
+	state, err := s.GetJobState(ctx, jobID)
+	if err != nil {
+		return "", err
+	}
+	return state.Status, nil
+}
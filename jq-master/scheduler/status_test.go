@@ -0,0 +1,44 @@
+package scheduler_test
+
+import (
+	"testing"
+
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+)
+
+func TestJobStatusTransitions(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{"", scheduler.JobStatusQueued, true},
+		{"", scheduler.JobStatusScheduled, true},
+		{"", scheduler.JobStatusWaiting, true},
+		{"", scheduler.JobStatusRunning, false},
+		{scheduler.JobStatusQueued, scheduler.JobStatusRunning, true},
+		{scheduler.JobStatusRetrying, scheduler.JobStatusRunning, true},
+		{scheduler.JobStatusRunning, scheduler.JobStatusRetrying, true},
+		{scheduler.JobStatusRunning, scheduler.JobStatusDone, true},
+		{scheduler.JobStatusRunning, scheduler.JobStatusError, true},
+		{scheduler.JobStatusWaiting, scheduler.JobStatusError, true},
+		// a dependent released with a future run_at
+		{scheduler.JobStatusWaiting, scheduler.JobStatusScheduled, true},
+		{scheduler.JobStatusQueued, scheduler.JobStatusScheduled, false},
+		{scheduler.JobStatusQueued, scheduler.JobStatusCancelled, true},
+		// backwards or repeated moves
+		{scheduler.JobStatusRunning, scheduler.JobStatusQueued, false},
+		{scheduler.JobStatusRunning, scheduler.JobStatusRunning, false},
+		{scheduler.JobStatusDone, scheduler.JobStatusRetrying, false},
+		{scheduler.JobStatusDone, scheduler.JobStatusRunning, false},
+		{scheduler.JobStatusError, scheduler.JobStatusRetrying, false},
+		{scheduler.JobStatusCancelled, scheduler.JobStatusQueued, false},
+		{scheduler.JobStatusQueued, scheduler.JobStatusWaiting, false},
+		{scheduler.JobStatusQueued, scheduler.JobStatusDone, false},
+	}
+
+	for _, c := range cases {
+		if got := scheduler.CanTransitionJobStatus(c.from, c.to); got != c.want {
+			t.Errorf("%q -> %q: expected %v, got %v", c.from, c.to, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RJobDeadlines = "jq:jobDeadlines" // job id -> dispatch time + timeout (unix millis)
+
+	DefaultJobTimeout   = 30 * time.Second
+	DefaultReapInterval = 1 * time.Second
+)
+
+// EffectiveTimeout returns the timeout applied to one attempt of the job.
+func (j Job) EffectiveTimeout() time.Duration {
+	if j.Timeout <= 0 {
+		return DefaultJobTimeout
+	}
+	return j.Timeout
+}
+
+// ReapTimedOutJobs periodically looks for dispatched jobs that exceeded their timeout
+// and sends a synthesized timeout failure for each of them to resultChan,
+// so that they go through the normal retry path in ProcessResult.
+func (s *Scheduler) ReapTimedOutJobs(ctx context.Context, resultChan chan<- transport.JobResult) {
+	ticker := time.NewTicker(s.reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// attempts known to their owner are acknowledged for it when they time out
+			if err := s.adoptInFlightJobs(ctx); err != nil {
+				log.Printf("error adopting in-flight jobs: %v", err)
+			}
+			if err := s.reapTimedOutJobs(ctx, resultChan); err != nil {
+				log.Printf("error reaping timed out jobs: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) reapTimedOutJobs(ctx context.Context, resultChan chan<- transport.JobResult) error {
+	now := time.Now()
+	jobIDs, err := s.r.ZRangeByScore(ctx, RJobDeadlines, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, jobID := range jobIDs {
+		// claim the deadline so that the timeout is reported only once
+		n, err := s.r.ZRem(ctx, RJobDeadlines, jobID).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+
+		log.Printf("job %s timed out", jobID)
+		select {
+		case resultChan <- timeoutResult(jobID, now):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func timeoutResult(jobID string, now time.Time) transport.JobResult {
+	return transport.JobResult{
+		JobID:       jobID,
+		Type:        transport.JobResultFailure,
+		FinishedAt:  now.Format(time.RFC3339),
+		Reason:      transport.JobFailureReasonTimeout,
+		ShouldRetry: true,
+		Message:     "job timed out",
+	}
+}
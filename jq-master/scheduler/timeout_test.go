@@ -0,0 +1,95 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestEffectiveTimeout(t *testing.T) {
+	job := scheduler.Job{Timeout: 5 * time.Second}
+	if job.EffectiveTimeout() != 5*time.Second {
+		t.Errorf("expected 5s, got %v", job.EffectiveTimeout())
+	}
+
+	job = scheduler.Job{}
+	if job.EffectiveTimeout() != scheduler.DefaultJobTimeout {
+		t.Errorf("expected default timeout for unset timeout, got %v", job.EffectiveTimeout())
+	}
+}
+
+// cleanupJob removes every trace of the job once the test ends.
+func cleanupJob(t *testing.T, r *redis.Client, jobID string) {
+	t.Helper()
+	t.Cleanup(func() {
+		ctx := context.Background()
+		for _, set := range []string{
+			scheduler.RScoredJobSet, scheduler.RJobDeadlines, scheduler.RDelayedJobSet,
+			scheduler.RScheduledJobSet, scheduler.RDeadJobSet, scheduler.RCancelledJobs,
+		} {
+			r.ZRem(ctx, set, jobID)
+		}
+		r.SRem(ctx, scheduler.RProcessingJobs, jobID)
+		r.SRem(ctx, scheduler.RCancelRequestedJobs, jobID)
+		r.HDel(ctx, scheduler.RJobOwners, jobID)
+		r.LRem(ctx, transport.RGlobalQueue, 0, jobID)
+		r.Del(ctx, transport.MakeJobKey(jobID), "jq:jobState:"+jobID, "jq:deadJob:"+jobID, "jq:result:"+jobID)
+	})
+}
+
+func TestReaperRetriesTimedOutJob(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	tran := transport.NewConn(r)
+	s := scheduler.NewScheduler(r, tran, scheduler.WithReapInterval(20*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	worker := scheduler.NewWorker("reaper-worker", "worker", 1)
+	if err := s.AddWorker(ctx, worker); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.RemoveWorker(context.Background(), worker.ID)
+	})
+
+	job := scheduler.Job{ID: "reaper-job", Name: "slow", Timeout: 100 * time.Millisecond, MaxRetry: 3}
+	cleanupJob(t, r, job.ID)
+	if _, err := s.AddJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	resultChan := make(chan transport.JobResult)
+	go s.DistributeJobs(ctx, resultChan)
+	go s.ReapTimedOutJobs(ctx, resultChan)
+
+	// nobody picks the job up from the global queue, so it times out
+	var result transport.JobResult
+	select {
+	case result = <-resultChan:
+	case <-ctx.Done():
+		t.Fatal("the job never timed out")
+	}
+	if result.JobID != job.ID || result.Reason != transport.JobFailureReasonTimeout {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	if err := s.ProcessResult(ctx, result); err != nil {
+		t.Fatal(err)
+	}
+	state, err := s.GetJobState(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != scheduler.JobStatusRetrying || state.RetryCount != 1 {
+		t.Errorf("expected the job to be retried once, got %+v", state)
+	}
+	if _, err := r.ZScore(ctx, scheduler.RDelayedJobSet, job.ID).Result(); err != nil {
+		t.Errorf("expected the job to wait for its retry: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	UniqueWhileQueued          = "queued"            // a duplicate may be enqueued once the job was dispatched
+	UniqueWhileQueuedOrRunning = "queued_or_running" // a duplicate may be enqueued once the job finished for good
+)
+
+// ErrDuplicateJob is returned when a job cannot take its unique key because another job holds it.
+var ErrDuplicateJob = errors.New("unique key held by another job")
+
+func makeUniqueKey(uniqueKey string) string {
+	return "jq:unique:" + uniqueKey
+}
+
+// takes the unique key for a job, unless a job that still exists holds it;
+// the job data is written along with the key, so a job holding the key always exists
+// KEYS[1]: unique key, KEYS[2]: job data, KEYS[3]: data of the job that held the key when it was read
+// ARGV[1]: job id, ARGV[2]: job data, ARGV[3]: id of the job that held the key when it was read ("" if none)
+// returns the id of the job holding the key; nil if the key changed hands since it was read
+var claimUniqueKeyScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1]) or ""
+if holder ~= ARGV[3] then
+	return false
+end
+if holder ~= "" and holder ~= ARGV[1] and redis.call("EXISTS", KEYS[3]) == 1 then
+	return holder
+end
+redis.call("SET", KEYS[1], ARGV[1])
+redis.call("SET", KEYS[2], ARGV[2])
+return ARGV[1]
+`)
+
+// gives up the unique key if the job still holds it
+// KEYS[1]: unique key
+// ARGV[1]: job id
+var releaseUniqueKeyScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+return 0
+`)
+
+// claimUniqueKey returns the id of the job holding the job's unique key; the job's own id if it got it.
+func (s *Scheduler) claimUniqueKey(ctx context.Context, job Job) (string, error) {
+	switch job.UniqueMode {
+	case "", UniqueWhileQueued, UniqueWhileQueuedOrRunning:
+	default:
+		return "", fmt.Errorf("unknown unique mode: %q", job.UniqueMode)
+	}
+
+	jobBin, err := msgpack.Marshal(&job)
+	if err != nil {
+		return "", err
+	}
+	uniqueKey := makeUniqueKey(job.UniqueKey)
+	for {
+		holderID, err := s.r.Get(ctx, uniqueKey).Result()
+		if err != nil && err != redis.Nil {
+			return "", err
+		}
+
+		claimed, err := claimUniqueKeyScript.Run(ctx, s.r,
+			[]string{uniqueKey, makeJobKey(job.ID), makeJobKey(holderID)},
+			job.ID, jobBin, holderID,
+		).Text()
+		if err == redis.Nil {
+			// the key changed hands in the meantime
+			continue
+		}
+		return claimed, err
+	}
+}
+
+// abandonUniqueKey gives up the unique key claimed for a job that could not be enqueued.
+func (s *Scheduler) abandonUniqueKey(ctx context.Context, job Job) error {
+	// the job data written with the claim would keep the key held
+	if err := s.r.Del(ctx, makeJobKey(job.ID)).Err(); err != nil {
+		return err
+	}
+	return s.releaseUniqueKey(ctx, job)
+}
+
+// releaseUniqueKey lets duplicates of the job be enqueued again.
+func (s *Scheduler) releaseUniqueKey(ctx context.Context, job Job) error {
+	if job.UniqueKey == "" {
+		return nil
+	}
+	return releaseUniqueKeyScript.Run(ctx, s.r, []string{makeUniqueKey(job.UniqueKey)}, job.ID).Err()
+}
+
+// releaseUniqueKeyOnDispatch releases the unique key of a job that is unique only while queued.
+func (s *Scheduler) releaseUniqueKeyOnDispatch(ctx context.Context, job Job) error {
+	if job.UniqueMode != "" && job.UniqueMode != UniqueWhileQueued {
+		return nil
+	}
+	return s.releaseUniqueKey(ctx, job)
+}
@@ -0,0 +1,113 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/scheduler"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+// cleanupUniqueKey removes the unique key once the test ends.
+func cleanupUniqueKey(t *testing.T, r *redis.Client, key string) {
+	t.Helper()
+	t.Cleanup(func() {
+		r.Del(context.Background(), "jq:unique:"+key)
+	})
+}
+
+// addUniqueJob adds a job under the unique key and returns the id of the job holding it.
+func addUniqueJob(t *testing.T, r *redis.Client, s *scheduler.Scheduler, jobID, key string) string {
+	t.Helper()
+	cleanupJob(t, r, jobID)
+	holderID, err := s.AddJob(context.Background(), scheduler.Job{
+		ID:         jobID,
+		Name:       "unique",
+		UniqueKey:  key,
+		UniqueMode: scheduler.UniqueWhileQueuedOrRunning,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return holderID
+}
+
+func TestUniqueKeyRejectsDuplicates(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+	key := "duplicates-" + t.Name()
+	cleanupUniqueKey(t, r, key)
+
+	if holderID := addUniqueJob(t, r, s, "unique-first", key); holderID != "unique-first" {
+		t.Fatalf("expected the first job to take the key, got %s", holderID)
+	}
+	if holderID := addUniqueJob(t, r, s, "unique-second", key); holderID != "unique-first" {
+		t.Errorf("expected the duplicate to be rejected, got %s", holderID)
+	}
+	if _, err := r.ZScore(context.Background(), scheduler.RScoredJobSet, "unique-second").Result(); err != redis.Nil {
+		t.Errorf("expected the duplicate not to be enqueued (%v)", err)
+	}
+}
+
+func TestUniqueKeyOfVanishedJobIsTaken(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+	key := "vanished-" + t.Name()
+	cleanupUniqueKey(t, r, key)
+
+	// the holder is gone without releasing the key
+	r.Set(context.Background(), "jq:unique:"+key, "unique-vanished", 0)
+	if holderID := addUniqueJob(t, r, s, "unique-next", key); holderID != "unique-next" {
+		t.Errorf("expected the key to be taken over, got %s", holderID)
+	}
+}
+
+func TestUniqueKeyReleasedOnCompletion(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+	key := "completion-" + t.Name()
+	cleanupUniqueKey(t, r, key)
+
+	addUniqueJob(t, r, s, "unique-completing", key)
+	job, err := popJob(s, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holderID := addUniqueJob(t, r, s, "unique-while-running", key); holderID != job.ID {
+		t.Errorf("expected the running job to keep the key, got %s", holderID)
+	}
+
+	finishJob(t, r, s, job.ID)
+	if holderID := addUniqueJob(t, r, s, "unique-after-completion", key); holderID != "unique-after-completion" {
+		t.Errorf("expected the key to be released on completion, got %s", holderID)
+	}
+}
+
+func TestUniqueKeyReleasedOnDeadLetter(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	ctx := context.Background()
+	s := scheduler.NewScheduler(r, transport.NewConn(r))
+	key := "dead-letter-" + t.Name()
+	cleanupUniqueKey(t, r, key)
+
+	addUniqueJob(t, r, s, "unique-failing", key)
+	job, err := popJob(s, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no retry is left, so the job is dead-lettered
+	r.SAdd(ctx, scheduler.RProcessingJobs, job.ID)
+	if err := s.ProcessResult(ctx, transport.JobResult{JobID: job.ID, Type: transport.JobResultFailure}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ZScore(ctx, scheduler.RDeadJobSet, job.ID).Result(); err != nil {
+		t.Fatalf("expected the job to be dead-lettered (%v)", err)
+	}
+	if holderID := addUniqueJob(t, r, s, "unique-after-dead-letter", key); holderID != "unique-after-dead-letter" {
+		t.Errorf("expected the key to be released on dead-letter, got %s", holderID)
+	}
+}
@@ -2,11 +2,15 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/lightpub-dev/lightjq/jq-master/ratelimit"
 	"github.com/lightpub-dev/lightjq/jq-master/transport"
 	"github.com/redis/go-redis/v9"
 	"github.com/vmihailenco/msgpack/v5"
@@ -19,12 +23,22 @@ func makeJobKey(jobID string) string {
 const (
 	RScoredJobSet   = "jq:scoredJobSet"
 	RProcessingJobs = "jq:processingJobs"
+
+	// how many queued jobs are looked at when searching for one no constraint defers
+	constraintScanLimit  = 1000
+	constraintScanBatch  = 100
+	dispatchPollInterval = 200 * time.Millisecond
 )
 
 type Worker struct {
-	ID           string
-	WorkerName   string
-	MaxProcesses int
+	ID           string `msgpack:"id"`
+	WorkerName   string `msgpack:"worker_name"`
+	MaxProcesses int    `msgpack:"max_processes"`
+	URL          string `msgpack:"url,omitempty"` // base url of a push worker; empty for workers pulling jobs from redis
+
+	running      int // jobs pushed to the worker and not finished yet
+	pushing      int // requests pushing a job to the worker
+	pushFailures int // pushes in a row that got no answer from the worker
 }
 
 type Job struct {
@@ -37,6 +51,10 @@ type Job struct {
 	KeepResult   bool                   `msgpack:"keep_result"`
 	Timeout      time.Duration          `msgpack:"timeout"`
 	RegisteredAt time.Time              `msgpack:"registered_at"`
+	RunAt        time.Time              `msgpack:"run_at"`                // zero to run as soon as possible
+	DependsOn    []string               `msgpack:"depends_on,omitempty"`  // jobs that have to succeed first
+	UniqueKey    string                 `msgpack:"unique_key,omitempty"`  // deduplication key; empty for none
+	UniqueMode   string                 `msgpack:"unique_mode,omitempty"` // how long the key is held; UniqueWhileQueued by default
 }
 
 func (j Job) CalculatePriorityScore() float64 {
@@ -48,11 +66,31 @@ type Scheduler struct {
 
 	workersMutex sync.Mutex
 	workers      []*Worker
+	pushedJobs   map[string]*Worker // job id -> push worker running it
 	maxProcesses int
 
-	tran *transport.Conn
+	reapInterval      time.Duration
+	promoteInterval   time.Duration
+	recurringInterval time.Duration
+	backoff           BackoffConfig
+	stateTTL          time.Duration
+	resultTTL         time.Duration
+
+	countOrphanedAttempts bool
+	maxPushesInFlight     int
+	maxPushFailures       int
+
+	constraintsMutex sync.Mutex
+	constraints      []Constraint
+	limiters         *ratelimit.RateLimiterCollection
+
+	tran       *transport.Conn
+	httpClient *http.Client
+	fence      atomic.Value // holds fenceHolder
 }
 
+var ErrWorkerNotFound = errors.New("worker not found")
+
 func NewWorker(id, workerName string, maxProcesses int) *Worker {
 	return &Worker{
 		ID:           id,
@@ -61,46 +99,141 @@ func NewWorker(id, workerName string, maxProcesses int) *Worker {
 	}
 }
 
-func NewScheduler(r *redis.Client, tran *transport.Conn) *Scheduler {
-	return &Scheduler{
-		r:            r,
-		workers:      make([]*Worker, 0),
-		maxProcesses: 0,
-		tran:         tran,
+func NewScheduler(r *redis.Client, tran *transport.Conn, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		r:                 r,
+		workers:           make([]*Worker, 0),
+		pushedJobs:        make(map[string]*Worker),
+		maxProcesses:      0,
+		reapInterval:      DefaultReapInterval,
+		promoteInterval:   DefaultPromoteInterval,
+		recurringInterval: DefaultRecurringInterval,
+		backoff:           DefaultBackoffConfig,
+		stateTTL:          DefaultJobStateTTL,
+		resultTTL:         DefaultResultTTL,
+		limiters:          ratelimit.NewRateLimitterCollection(),
+		tran:              tran,
+		httpClient:        &http.Client{Timeout: DefaultPushTimeout},
+
+		countOrphanedAttempts: true,
+		maxPushesInFlight:     DefaultMaxPushesInFlight,
+		maxPushFailures:       DefaultMaxPushFailures,
 	}
+
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+
+	return s
 }
 
-func (s *Scheduler) AddWorker(w *Worker) {
+// AddWorker registers a worker, replacing a registered one with the same id.
+func (s *Scheduler) AddWorker(ctx context.Context, w *Worker) error {
+	if err := s.saveWorker(ctx, w); err != nil {
+		return err
+	}
+
 	s.workersMutex.Lock()
 	defer s.workersMutex.Unlock()
 
+	for i, worker := range s.workers {
+		if worker.ID == w.ID {
+			// keep the slots taken by jobs already pushed to it
+			w.running = worker.running
+			for jobID, pushedTo := range s.pushedJobs {
+				if pushedTo == worker {
+					s.pushedJobs[jobID] = w
+				}
+			}
+			s.workers[i] = w
+			s.maxProcesses += w.MaxProcesses - worker.MaxProcesses
+			return nil
+		}
+	}
 	s.workers = append(s.workers, w)
 	s.maxProcesses += w.MaxProcesses
+	return nil
 }
 
-func (s *Scheduler) RemoveWorker(workerID string) {
+// HasWorker reports whether the worker is registered.
+func (s *Scheduler) HasWorker(workerID string) bool {
 	s.workersMutex.Lock()
 	defer s.workersMutex.Unlock()
 
+	for _, worker := range s.workers {
+		if worker.ID == workerID {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveWorker unregisters a worker. Jobs it was running are retried.
+func (s *Scheduler) RemoveWorker(ctx context.Context, workerID string) error {
+	s.workersMutex.Lock()
+	var removed *Worker
 	for i, worker := range s.workers {
 		if worker.ID == workerID {
 			s.workers = append(s.workers[:i], s.workers[i+1:]...)
 			s.maxProcesses -= worker.MaxProcesses
-			return
+			removed = worker
+			break
 		}
 	}
+	s.workersMutex.Unlock()
+
+	if removed == nil {
+		return ErrWorkerNotFound
+	}
+	if err := s.deleteWorker(ctx, workerID); err != nil {
+		return err
+	}
+	return s.recoverOrphanedJobs(ctx, workerID)
 }
 
 func (s *Scheduler) getCurrentProcessingJobsN(ctx context.Context) (int64, error) {
 	return s.r.SCard(ctx, RProcessingJobs).Result()
 }
 
-func (s *Scheduler) addToProcessingJobs(ctx context.Context, jobID string) error {
-	return s.r.SAdd(ctx, RProcessingJobs, jobID).Err()
+func (s *Scheduler) addToProcessingJobs(ctx context.Context, job Job) error {
+	deadline := time.Now().Add(job.EffectiveTimeout())
+
+	return s.fencedTxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.SAdd(ctx, RProcessingJobs, job.ID)
+		tx.ZAdd(ctx, RJobDeadlines, redis.Z{
+			Score:  float64(deadline.UnixMilli()),
+			Member: job.ID,
+		})
+		s.recordJobState(ctx, tx, job.ID, JobStatusRunning, "retry_count", job.CurrentRetry)
+		return nil
+	})
 }
 
-func (s *Scheduler) removeFromProcessingJobs(ctx context.Context, jobID string) error {
-	return s.r.SRem(ctx, RProcessingJobs, jobID).Err()
+// removeFromProcessingJobs reports whether the job was still being processed.
+// Finishing an attempt decides what happens to the job next, so it is fenced as well.
+func (s *Scheduler) removeFromProcessingJobs(ctx context.Context, jobID string) (bool, error) {
+	owner, err := s.getJobOwner(ctx, jobID)
+	if err != nil {
+		return false, err
+	}
+
+	var srem *redis.IntCmd
+	err = s.fencedTxPipelined(ctx, func(tx redis.Pipeliner) error {
+		srem = tx.SRem(ctx, RProcessingJobs, jobID)
+		tx.ZRem(ctx, RJobDeadlines, jobID)
+		tx.HDel(ctx, RJobOwners, jobID)
+		if owner != "" {
+			tx.SRem(ctx, makeOwnedJobsKey(owner), jobID)
+			// acknowledge the attempt for a worker that will not (e.g. it timed out),
+			// so that no leftover entry outlives it
+			tx.LRem(ctx, transport.MakeInFlightKey(owner), 0, jobID)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return srem.Val() > 0, nil
 }
 
 func (s *Scheduler) HasEmpty(ctx context.Context) (bool, error) {
@@ -108,63 +241,277 @@ func (s *Scheduler) HasEmpty(ctx context.Context) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+
+	s.workersMutex.Lock()
+	defer s.workersMutex.Unlock()
 	return n < int64(s.maxProcesses), nil
 }
 
-func (s *Scheduler) AddJob(ctx context.Context, job Job) error {
-	// priority
+// AddJob enqueues a job and returns its id. Jobs with DependsOn wait until all of their parents succeed.
+// If another job with the same UniqueKey is still pending, nothing is enqueued and the id of that job is returned.
+func (s *Scheduler) AddJob(ctx context.Context, job Job) (string, error) {
+	if job.UniqueKey != "" {
+		holderID, err := s.claimUniqueKey(ctx, job)
+		if err != nil {
+			return "", err
+		}
+		if holderID != job.ID {
+			log.Printf("job %s is a duplicate of job %s", job.ID, holderID)
+			return holderID, nil
+		}
+	}
 
-	// transaction
-	tx := s.r.TxPipeline()
+	var err error
+	if len(job.DependsOn) > 0 {
+		err = s.addDependentJob(ctx, job)
+	} else {
+		err = s.enqueueJob(ctx, job)
+	}
+	if err != nil {
+		if job.UniqueKey != "" {
+			if releaseErr := s.abandonUniqueKey(ctx, job); releaseErr != nil {
+				log.Printf("error releasing unique key of job %s: %v", job.ID, releaseErr)
+			}
+		}
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// enqueueJob makes the job eligible for distribution, or schedules it for its run time.
+func (s *Scheduler) enqueueJob(ctx context.Context, job Job) error {
+	_, err := s.r.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		return s.queueJob(ctx, tx, job)
+	})
+	return err
+}
+
+// enqueueJobOnce enqueues the job unless a job with its id was already enqueued,
+// as far as its data or state is still kept. It reports whether the job was enqueued.
+func (s *Scheduler) enqueueJobOnce(ctx context.Context, job Job) (bool, error) {
+	keys := []string{makeJobKey(job.ID), makeJobStateKey(job.ID)}
+	enqueued := false
+	err := s.r.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, keys...).Result()
+		if err != nil || n > 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return s.queueJob(ctx, pipe, job)
+		})
+		enqueued = err == nil
+		return err
+	}, keys...)
+	return enqueued, err
+}
 
+// queueJob adds the commands enqueueing the job to tx.
+func (s *Scheduler) queueJob(ctx context.Context, tx redis.Pipeliner, job Job) error {
 	// register job info to job list
 	jobBin, err := msgpack.Marshal(&job)
 	if err != nil {
 		return err
 	}
-	if _, err := tx.Set(ctx, makeJobKey(job.ID), jobBin, 0).Result(); err != nil {
-		return err
+	tx.Set(ctx, makeJobKey(job.ID), jobBin, 0)
+
+	if job.RunAt.After(time.Now()) {
+		// hold the job back until its time has come
+		s.recordJobState(ctx, tx, job.ID, JobStatusScheduled, "retry_count", job.CurrentRetry)
+		tx.ZAdd(ctx, RScheduledJobSet, redis.Z{
+			Score:  float64(job.RunAt.UnixMilli()),
+			Member: job.ID,
+		})
+	} else {
+		// push job id to scored job set
+		s.recordJobState(ctx, tx, job.ID, JobStatusQueued, "retry_count", job.CurrentRetry)
+		tx.ZAdd(ctx, RScoredJobSet, redis.Z{
+			Score:  job.CalculatePriorityScore(),
+			Member: job.ID,
+		})
 	}
+	return nil
+}
 
-	// push job id to scored job set
-	if _, err := tx.ZAdd(ctx, RScoredJobSet, redis.Z{
-		Score:  job.CalculatePriorityScore(),
-		Member: job.ID,
-	}).Result(); err != nil {
-		return err
+func (s *Scheduler) getJob(ctx context.Context, jobID string) (Job, error) {
+	jobBin, err := s.r.Get(ctx, makeJobKey(jobID)).Bytes()
+	if err != nil {
+		return Job{}, err
 	}
 
-	// commit
-	if _, err := tx.Exec(ctx); err != nil {
-		return err
+	var job Job
+	if err := msgpack.Unmarshal(jobBin, &job); err != nil {
+		return Job{}, fmt.Errorf("failed to unmarshal job: %w", err)
 	}
 
-	return nil
+	return job, nil
 }
 
+// BlockJobPop waits for the job with the best priority that no constraint defers and removes it from the queue.
 func (s *Scheduler) BlockJobPop(ctx context.Context) (Job, error) {
-	// pop job from scored job set
-	js, err := s.r.BZPopMin(ctx, 0, RScoredJobSet).Result()
-	if err != nil {
-		return Job{}, err
+	constraints := s.getConstraints()
+	if len(constraints) == 0 {
+		// pop job from scored job set
+		js, err := s.r.BZPopMin(ctx, 0, RScoredJobSet).Result()
+		if err != nil {
+			return Job{}, err
+		}
+
+		// get job info
+		return s.getJob(ctx, js.Member.(string))
+	}
+
+	for {
+		job, ok, err := s.popEligibleJob(ctx, constraints)
+		if err != nil {
+			return Job{}, err
+		}
+		if ok {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		case <-time.After(dispatchPollInterval):
+		}
+	}
+}
+
+// popEligibleJob scans queued jobs in priority order and pops the first one every constraint allows.
+// Deferred jobs do not block jobs behind them: those whose constraint knows when they will be allowed
+// are parked in the delayed set until then, and those waiting for capacity of a parking constraint
+// are parked by it until the capacity is released.
+func (s *Scheduler) popEligibleJob(ctx context.Context, constraints []Constraint) (Job, bool, error) {
+	for start := int64(0); start < constraintScanLimit; start += constraintScanBatch {
+		jobIDs, err := s.r.ZRange(ctx, RScoredJobSet, start, start+constraintScanBatch-1).Result()
+		if err != nil {
+			return Job{}, false, err
+		}
+		jobs, err := s.getJobs(ctx, jobIDs)
+		if err != nil {
+			return Job{}, false, err
+		}
+
+		parked := int64(0)
+		for i, jobID := range jobIDs {
+			job, found := jobs[i]
+			if !found {
+				log.Printf("queued job %s has no job data", jobID)
+				continue
+			}
+
+			ok, retryAt, denied, err := s.acquireConstraints(ctx, job, constraints)
+			if err != nil {
+				return Job{}, false, err
+			}
+			if !ok {
+				moved, err := s.parkDeniedJob(ctx, job, retryAt, denied)
+				if err != nil {
+					log.Printf("error parking job %s: %v", jobID, err)
+				}
+				if moved {
+					parked++
+				}
+				continue
+			}
+
+			// another scheduler may have taken it in the meantime
+			n, err := s.r.ZRem(ctx, RScoredJobSet, jobID).Result()
+			if err != nil {
+				return Job{}, false, err
+			}
+			if n > 0 {
+				return job, true, nil
+			}
+			if err := s.releaseConstraints(ctx, jobID, constraints); err != nil {
+				log.Printf("error releasing constraints of job %s: %v", jobID, err)
+			}
+		}
+
+		if len(jobIDs) < constraintScanBatch {
+			break
+		}
+		// parked jobs left the set, so the following ones moved up
+		start -= parked
 	}
 
-	// get job info
-	jobBin, err := s.r.Get(ctx, makeJobKey(js.Member.(string))).Bytes()
+	return Job{}, false, nil
+}
+
+// parkDeniedJob keeps a job a constraint denied out of the way until it may be allowed.
+// It reports whether the job left the scored job set.
+func (s *Scheduler) parkDeniedJob(ctx context.Context, job Job, retryAt time.Time, denied Constraint) (bool, error) {
+	if !retryAt.IsZero() {
+		return s.parkJob(ctx, job.ID, retryAt)
+	}
+	if pc, ok := denied.(parkingConstraint); ok {
+		return pc.park(ctx, job)
+	}
+	return false, nil
+}
+
+// getJobs returns the data of the jobs found, keyed by their index in jobIDs.
+func (s *Scheduler) getJobs(ctx context.Context, jobIDs []string) (map[int]Job, error) {
+	jobs := make(map[int]Job, len(jobIDs))
+	if len(jobIDs) == 0 {
+		return jobs, nil
+	}
+
+	keys := make([]string, len(jobIDs))
+	for i, jobID := range jobIDs {
+		keys[i] = makeJobKey(jobID)
+	}
+	jobBins, err := s.r.MGet(ctx, keys...).Result()
 	if err != nil {
-		return Job{}, err
+		return nil, err
 	}
 
-	var job Job
-	if err := msgpack.Unmarshal(jobBin, &job); err != nil {
-		return Job{}, fmt.Errorf("failed to unmarshal job: %w", err)
+	for i, jobBin := range jobBins {
+		bin, ok := jobBin.(string)
+		if !ok {
+			continue
+		}
+		var job Job
+		if err := msgpack.Unmarshal([]byte(bin), &job); err != nil {
+			log.Printf("error unmarshalling job %s: %v", jobIDs[i], err)
+			continue
+		}
+		jobs[i] = job
 	}
+	return jobs, nil
+}
 
-	return job, nil
+// requeueUndispatchedJob puts back a job that was popped but reached no worker.
+func (s *Scheduler) requeueUndispatchedJob(ctx context.Context, job Job) {
+	if _, err := s.removeFromProcessingJobs(ctx, job.ID); err != nil {
+		log.Printf("error removing job from processing jobs: %v", err)
+	}
+	if err := s.releaseConstraints(ctx, job.ID, s.getConstraints()); err != nil {
+		log.Printf("error releasing constraints of job %s: %v", job.ID, err)
+	}
+	// no worker has seen the job, so it is queued again
+	if err := s.forceJobState(ctx, s.r, job.ID, JobStatusQueued).Err(); err != nil {
+		log.Printf("error recording state of job %s: %v", job.ID, err)
+	}
+	if err := s.enqueueJob(ctx, job); err != nil {
+		log.Printf("error re-enqueueing job %s: %v", job.ID, err)
+	}
 }
 
-func (s *Scheduler) DistributeJobs(ctx context.Context) error {
+// DistributeJobs hands queued jobs to workers while they have free slots.
+// Jobs are pushed to push workers first and left in the global queue for pulling workers otherwise;
+// jobs no worker accepts are reported as failed to resultChan.
+func (s *Scheduler) DistributeJobs(ctx context.Context, resultChan chan<- transport.JobResult) error {
 	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.checkFence(ctx); err != nil {
+			log.Printf("not dispatching jobs: %v", err)
+			time.Sleep(dispatchPollInterval)
+			continue
+		}
+
 		workerAvailable, err := s.HasEmpty(ctx)
 		if err != nil {
 			log.Printf("failed to scard processing jobs: %v", err)
@@ -178,18 +525,29 @@ func (s *Scheduler) DistributeJobs(ctx context.Context) error {
 				continue
 			}
 
-			if err := s.addToProcessingJobs(ctx, job.ID); err != nil {
-				log.Printf("error adding job to processing jobs: %v", err)
-				log.Printf("ignoring this error and continue")
+			if err := s.releaseUniqueKeyOnDispatch(ctx, job); err != nil {
+				log.Printf("error releasing unique key of job %s: %v", job.ID, err)
 			}
 
-			if err := s.tran.DistributeJob(ctx, job.ID); err != nil {
-				log.Printf("error distributing job: %v", err)
-				if err := s.removeFromProcessingJobs(ctx, job.ID); err != nil {
-					log.Printf("error removing job from processing jobs: %v", err)
+			if err := s.addToProcessingJobs(ctx, job); err != nil {
+				// an untracked attempt would never time out, so put the job back instead
+				log.Printf("error adding job to processing jobs: %v", err)
+				if err := s.releaseConstraints(ctx, job.ID, s.getConstraints()); err != nil {
+					log.Printf("error releasing constraints of job %s: %v", job.ID, err)
+				}
+				if err := s.enqueueJob(ctx, job); err != nil {
+					log.Printf("error re-enqueueing job %s: %v", job.ID, err)
 				}
 				continue
 			}
+
+			if w := s.reservePushSlot(job.ID, nil); w != nil {
+				go s.pushJob(ctx, job, w, resultChan)
+				continue
+			}
+			if s.handOverUnpushedJob(ctx, job, nil, resultChan) {
+				time.Sleep(dispatchPollInterval)
+			}
 		} else {
 			time.Sleep(1 * time.Second)
 		}
@@ -2,8 +2,11 @@ package transport
 
 import (
 	"context"
+	"errors"
 	"log"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
@@ -11,6 +14,11 @@ func MakeJobKey(jobID string) string {
 	return "jq:job:" + jobID
 }
 
+// MakeInFlightKey returns the list holding the jobs a worker took from the global queue and has not acknowledged yet.
+func MakeInFlightKey(workerID string) string {
+	return "jq:inflight:" + workerID
+}
+
 func (c *Conn) DistributeJob(ctx context.Context, jobID string) error {
 	_, err := c.r.RPush(ctx, RGlobalQueue, jobID).Result()
 	if err != nil {
@@ -32,3 +40,78 @@ func (c *Conn) PublishResult(ctx context.Context, result JobResult) error {
 
 	return nil
 }
+
+type CancelMessage struct {
+	JobID string `msgpack:"job_id"`
+}
+
+func (c *Conn) PublishCancel(ctx context.Context, jobID string) error {
+	cancelBin, err := msgpack.Marshal(&CancelMessage{JobID: jobID})
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.r.Publish(ctx, RCancelPubSubKey, cancelBin).Result(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SubscribeResults calls onResult for every result published to pushers until ctx is done.
+func (c *Conn) SubscribeResults(ctx context.Context, onResult func(JobResult)) error {
+	sub := c.r.Subscribe(ctx, RResultPubSubKey)
+	defer sub.Close()
+
+	// wait for the subscription so that no result published afterwards is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var result JobResult
+			if err := msgpack.Unmarshal([]byte(msg.Payload), &result); err != nil {
+				log.Printf("invalid published result: %v", err)
+				continue
+			}
+			onResult(result)
+		}
+	}
+}
+
+// StoreResult keeps the result until it is taken or ttl elapses.
+func (c *Conn) StoreResult(ctx context.Context, result JobResult, ttl time.Duration) error {
+	resultBin, err := msgpack.Marshal(&result)
+	if err != nil {
+		return err
+	}
+
+	return c.r.Set(ctx, MakeJobResultKey(result.JobID), resultBin, ttl).Err()
+}
+
+// TakeResult returns the stored result of a job and discards it.
+// It returns nil if no result is stored, i.e. the job did not keep its result,
+// the result expired or it has already been taken.
+func (c *Conn) TakeResult(ctx context.Context, jobID string) (*JobResult, error) {
+	resultBin, err := c.r.GetDel(ctx, MakeJobResultKey(jobID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result JobResult
+	if err := msgpack.Unmarshal(resultBin, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
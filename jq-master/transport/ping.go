@@ -3,6 +3,7 @@ package transport
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/vmihailenco/msgpack/v5"
@@ -10,31 +11,87 @@ import (
 
 const (
 	RPingSub         = "jq:ping"
-	PingDropInterval = 10 * time.Second
+	DefaultPingGrace = 10 * time.Second // how long a worker may stay silent before it is considered dead
 )
 
-type PingFailure struct {
+const (
+	WorkerJoined = "joined"
+	WorkerLeft   = "left"
+)
+
+// LivenessEvent tells that a worker started or stopped pinging.
+type LivenessEvent struct {
+	Type     string
 	WorkerID string
 }
 
-type PingChecker struct {
-	conn     *Conn
-	workerID string
+type pingMessage struct {
+	WorkerID string `msgpack:"worker_id"`
 }
 
-func NewPingChecker(conn *Conn, workerID string) *PingChecker {
-	return &PingChecker{conn: conn, workerID: workerID}
+// LivenessTracker keeps track of which workers are alive using a single subscription to their pings.
+type LivenessTracker struct {
+	conn  *Conn
+	grace time.Duration
+
+	mutex    sync.Mutex
+	lastSeen map[string]time.Time // worker id -> time of its last ping
 }
 
-type pingMessage struct {
-	WorkerID string `msgpack:"worker_id"`
+func NewLivenessTracker(conn *Conn, grace time.Duration) *LivenessTracker {
+	return &LivenessTracker{
+		conn:     conn,
+		grace:    grace,
+		lastSeen: make(map[string]time.Time),
+	}
 }
 
-func (pc *PingChecker) PingCheckLoop(ctx context.Context, onFailure func(PingFailure)) {
-	timer := time.NewTicker(PingDropInterval)
-	defer timer.Stop()
+// Track starts watching a worker that has not pinged yet, so that it leaves if it never does.
+func (t *LivenessTracker) Track(workerID string, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.lastSeen[workerID]; !ok {
+		t.lastSeen[workerID] = now
+	}
+}
+
+// Forget stops watching a worker without reporting it as left.
+func (t *LivenessTracker) Forget(workerID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.lastSeen, workerID)
+}
 
-	sub := pc.conn.r.Subscribe(ctx, RPingSub)
+// Seen records a ping of the worker and reports whether the worker was not known to be alive before.
+func (t *LivenessTracker) Seen(workerID string, now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, known := t.lastSeen[workerID]
+	t.lastSeen[workerID] = now
+	return !known
+}
+
+// Expire forgets and returns the workers that have been silent for longer than the grace period.
+func (t *LivenessTracker) Expire(now time.Time) []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var expired []string
+	for workerID, lastSeen := range t.lastSeen {
+		if now.Sub(lastSeen) > t.grace {
+			expired = append(expired, workerID)
+			delete(t.lastSeen, workerID)
+		}
+	}
+	return expired
+}
+
+// Run consumes pings and sends an event to events whenever a worker joins or leaves, until ctx is done.
+func (t *LivenessTracker) Run(ctx context.Context, events chan<- LivenessEvent) {
+	sub := t.conn.r.Subscribe(ctx, RPingSub)
 	defer func() {
 		err := sub.Close()
 		if err != nil {
@@ -43,23 +100,43 @@ func (pc *PingChecker) PingCheckLoop(ctx context.Context, onFailure func(PingFai
 	}()
 	subChan := sub.Channel()
 
+	// check often enough that a dead worker is noticed soon after its grace period
+	ticker := time.NewTicker(t.grace / 2)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-timer.C:
-			// timer fired before receiving ping
-			// assume that the worker is dead
-			pingChan := PingFailure{WorkerID: pc.workerID}
-			onFailure(pingChan)
-			return
+		case now := <-ticker.C:
+			expired := t.Expire(now)
+			for i, workerID := range expired {
+				select {
+				case events <- LivenessEvent{Type: WorkerLeft, WorkerID: workerID}:
+				case <-ctx.Done():
+					// keep the workers not reported yet expired, so that the next run reports them
+					for _, workerID := range expired[i:] {
+						t.Track(workerID, now.Add(-t.grace))
+					}
+					return
+				}
+			}
 		case msg := <-subChan:
 			var ping pingMessage
 			if err := msgpack.Unmarshal([]byte(msg.Payload), &ping); err != nil {
 				log.Printf("invalid ping message: %v", err)
 				continue
 			}
-			timer.Reset(PingDropInterval)
+			if ping.WorkerID == "" {
+				continue
+			}
+			if t.Seen(ping.WorkerID, time.Now()) {
+				select {
+				case events <- LivenessEvent{Type: WorkerJoined, WorkerID: ping.WorkerID}:
+				case <-ctx.Done():
+					return
+				}
+			}
 		}
 	}
 }
@@ -0,0 +1,98 @@
+package transport_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+)
+
+func TestLivenessTrackerPerWorker(t *testing.T) {
+	tracker := transport.NewLivenessTracker(nil, 10*time.Second)
+	start := time.Unix(1700000000, 0)
+
+	if !tracker.Seen("a", start) {
+		t.Error("first ping of a should join")
+	}
+	if !tracker.Seen("b", start) {
+		t.Error("first ping of b should join")
+	}
+
+	// only a keeps pinging; it must not keep b alive
+	for i := 1; i <= 3; i++ {
+		if tracker.Seen("a", start.Add(time.Duration(i)*5*time.Second)) {
+			t.Error("a joined twice")
+		}
+	}
+
+	expired := tracker.Expire(start.Add(15 * time.Second))
+	if len(expired) != 1 || expired[0] != "b" {
+		t.Errorf("expected b to expire, got %v", expired)
+	}
+	if expired := tracker.Expire(start.Add(15 * time.Second)); len(expired) != 0 {
+		t.Errorf("expected nothing to expire twice, got %v", expired)
+	}
+
+	// a worker that comes back joins again
+	if !tracker.Seen("b", start.Add(16*time.Second)) {
+		t.Error("b should join again")
+	}
+}
+
+func TestLivenessTrackerTrack(t *testing.T) {
+	tracker := transport.NewLivenessTracker(nil, 10*time.Second)
+	start := time.Unix(1700000000, 0)
+
+	tracker.Track("silent", start)
+	tracker.Track("forgotten", start)
+	tracker.Forget("forgotten")
+	if !tracker.Seen("pinging", start) {
+		t.Error("expected pinging to join")
+	}
+	if tracker.Seen("silent", start.Add(5*time.Second)) {
+		t.Error("tracked worker should not join on its first ping")
+	}
+
+	expired := tracker.Expire(start.Add(30 * time.Second))
+	sort.Strings(expired)
+	if len(expired) != 2 || expired[0] != "pinging" || expired[1] != "silent" {
+		t.Errorf("unexpected expired workers: %v", expired)
+	}
+}
+
+func TestLivenessTrackerKeepsUnreportedLeaves(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	tracker := transport.NewLivenessTracker(transport.NewConn(r), 100*time.Millisecond)
+	tracker.Track("silent", time.Now())
+
+	// nobody reads the events of the first run
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		tracker.Run(ctx, make(chan transport.LivenessEvent))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not stop with its context")
+	}
+
+	// the next run still reports the worker as left
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	events := make(chan transport.LivenessEvent)
+	go tracker.Run(ctx, events)
+	select {
+	case ev := <-events:
+		if ev.Type != transport.WorkerLeft || ev.WorkerID != "silent" {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-ctx.Done():
+		t.Error("the worker was never reported as left")
+	}
+}
@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const ContentTypeMsgpack = "application/msgpack"
+
+var (
+	ErrJobRejected    = errors.New("worker rejected the job as invalid")
+	ErrUnknownJobName = errors.New("worker does not know the job name")
+)
+
+// WorkerJobRequest is the body master sends to the /worker-job endpoint of push workers.
+type WorkerJobRequest struct {
+	ID       string                 `msgpack:"id"`
+	Name     string                 `msgpack:"name"`
+	Argument map[string]interface{} `msgpack:"argument"`
+	Timeout  int                    `msgpack:"timeout"` // seconds
+}
+
+// PushJob asks the worker at workerURL to execute a job. It returns nil once the worker accepted it.
+func PushJob(ctx context.Context, client *http.Client, workerURL string, job WorkerJobRequest) error {
+	body, err := msgpack.Marshal(&job)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(workerURL, "/")+"/worker-job", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentTypeMsgpack)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return nil
+	case http.StatusBadRequest:
+		return ErrJobRejected
+	case http.StatusNotFound:
+		return ErrUnknownJobName
+	default:
+		return fmt.Errorf("unexpected response from worker: %s", resp.Status)
+	}
+}
+
+// CancelPushedJob asks the worker at workerURL to stop a job pushed to it.
+// A worker that does not know the job any more has already finished it, which is not an error.
+func CancelPushedJob(ctx context.Context, client *http.Client, workerURL, jobID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, strings.TrimSuffix(workerURL, "/")+"/worker-job/"+url.PathEscape(jobID), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("unexpected response from worker: %s", resp.Status)
+	}
+}
+
+// SubmitResult hands a result reported over HTTP to master's result queue.
+func (c *Conn) SubmitResult(ctx context.Context, result JobResult) error {
+	resultBin, err := msgpack.Marshal(&result)
+	if err != nil {
+		return err
+	}
+
+	return c.r.RPush(ctx, RResultQueue, resultBin).Err()
+}
@@ -0,0 +1,86 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestPushJob(t *testing.T) {
+	cases := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusAccepted, nil},
+		{http.StatusBadRequest, transport.ErrJobRejected},
+		{http.StatusNotFound, transport.ErrUnknownJobName},
+	}
+
+	for _, c := range cases {
+		var got transport.WorkerJobRequest
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/worker-job" {
+				t.Errorf("unexpected path %s", req.URL.Path)
+			}
+			if err := msgpack.NewDecoder(req.Body).Decode(&got); err != nil {
+				t.Errorf("invalid body: %v", err)
+			}
+			w.WriteHeader(c.status)
+		}))
+
+		job := transport.WorkerJobRequest{ID: "job-1", Name: "resize", Argument: map[string]interface{}{"w": 100}, Timeout: 30}
+		err := transport.PushJob(context.Background(), srv.Client(), srv.URL+"/", job)
+		srv.Close()
+
+		if !errors.Is(err, c.want) {
+			t.Errorf("status %d: expected %v, got %v", c.status, c.want, err)
+		}
+		if got.ID != job.ID || got.Name != job.Name || got.Timeout != job.Timeout {
+			t.Errorf("status %d: worker received %+v", c.status, got)
+		}
+	}
+}
+
+func TestPushJobUnexpectedStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := transport.PushJob(context.Background(), srv.Client(), srv.URL, transport.WorkerJobRequest{ID: "job-1"})
+	if err == nil || errors.Is(err, transport.ErrJobRejected) || errors.Is(err, transport.ErrUnknownJobName) {
+		t.Errorf("expected a generic error, got %v", err)
+	}
+}
+
+func TestCancelPushedJob(t *testing.T) {
+	cases := []struct {
+		status  int
+		wantErr bool
+	}{
+		{http.StatusAccepted, false},
+		{http.StatusNoContent, false},
+		{http.StatusNotFound, false}, // the job already finished
+		{http.StatusInternalServerError, true},
+	}
+
+	for _, c := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodDelete || req.URL.Path != "/worker-job/job-1" {
+				t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			}
+			w.WriteHeader(c.status)
+		}))
+		err := transport.CancelPushedJob(context.Background(), srv.Client(), srv.URL+"/", "job-1")
+		srv.Close()
+
+		if (err != nil) != c.wantErr {
+			t.Errorf("status %d: unexpected error %v", c.status, err)
+		}
+	}
+}
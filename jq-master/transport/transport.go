@@ -2,7 +2,9 @@ package transport
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/vmihailenco/msgpack/v5"
@@ -22,6 +24,7 @@ const (
 	RJobList         = "jq:jobList"        // used to receive new jobs from pushers
 	RResultPubSubKey = "jq:result"         // used to publish results to pushers
 	RResultQueue     = "jq:resultQueue"    // used to receive results from workers
+	RCancelPubSubKey = "jq:cancel"         // used to ask workers to cancel running jobs
 )
 
 func MakeJobResultKey(jobID string) string {
@@ -32,6 +35,16 @@ type WorkerRegisterRequest struct {
 	ID         string `msgpack:"id"`
 	WorkerName string `msgpack:"worker_name"`
 	Processes  int    `msgpack:"processes"`
+	URL        string `msgpack:"url,omitempty"` // set by workers that want jobs pushed over HTTP
+}
+
+// putBack returns an item popped from a queue that was not handed over,
+// so that the next master to poll the queue receives it.
+func (c *Conn) putBack(key, item string) {
+	// the context of the poller is already done here
+	if err := c.r.LPush(context.Background(), key, item).Err(); err != nil {
+		log.Printf("error putting back item of %s: %v", key, err)
+	}
 }
 
 func (c *Conn) PollNewClient(ctx context.Context, workerChan chan<- WorkerRegisterRequest) {
@@ -39,6 +52,9 @@ func (c *Conn) PollNewClient(ctx context.Context, workerChan chan<- WorkerRegist
 	for {
 		s, err := c.r.BLPop(ctx, 0, RWorkerRegister).Result()
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			panic(err)
 		}
 		workerInfoPack := s[1]
@@ -49,7 +65,12 @@ func (c *Conn) PollNewClient(ctx context.Context, workerChan chan<- WorkerRegist
 		}
 
 		log.Printf("worker registered: %v", workerInfo)
-		workerChan <- workerInfo
+		select {
+		case workerChan <- workerInfo:
+		case <-ctx.Done():
+			c.putBack(RWorkerRegister, workerInfoPack)
+			return
+		}
 	}
 }
 
@@ -61,6 +82,32 @@ type JobRegisterRequest struct {
 	MaxRetry   int                    `msgpack:"max_retry"`
 	KeepResult bool                   `msgpack:"keep_result"`
 	Timeout    int                    `msgpack:"timeout"`
+
+	// Ids of jobs that have to succeed before this one runs
+	DependsOn []string `msgpack:"depends_on,omitempty"`
+
+	// Optional deduplication; see scheduler.UniqueWhileQueued and scheduler.UniqueWhileQueuedOrRunning
+	UniqueKey  string `msgpack:"unique_key,omitempty"`
+	UniqueMode string `msgpack:"unique_mode,omitempty"`
+
+	// Optional scheduling; RunAt takes precedence over Delay
+	RunAt string `msgpack:"run_at,omitempty"` // ISO 8601
+	Delay int    `msgpack:"delay,omitempty"`  // seconds
+}
+
+// ScheduledAt returns when the job should run, or the zero time to run it as soon as possible.
+func (j JobRegisterRequest) ScheduledAt(now time.Time) (time.Time, error) {
+	if j.RunAt != "" {
+		runAt, err := time.Parse(time.RFC3339, j.RunAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid run_at: %w", err)
+		}
+		return runAt, nil
+	}
+	if j.Delay > 0 {
+		return now.Add(time.Duration(j.Delay) * time.Second), nil
+	}
+	return time.Time{}, nil
 }
 
 func (c *Conn) PollNewJob(ctx context.Context, jobChan chan<- JobRegisterRequest) {
@@ -68,6 +115,9 @@ func (c *Conn) PollNewJob(ctx context.Context, jobChan chan<- JobRegisterRequest
 	for {
 		s, err := c.r.BLPop(ctx, 0, RJobList).Result()
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			panic(err)
 		}
 		jobPack := s[1]
@@ -78,7 +128,12 @@ func (c *Conn) PollNewJob(ctx context.Context, jobChan chan<- JobRegisterRequest
 		}
 
 		log.Printf("job added: %v", job)
-		jobChan <- job
+		select {
+		case jobChan <- job:
+		case <-ctx.Done():
+			c.putBack(RJobList, jobPack)
+			return
+		}
 	}
 }
 
@@ -87,19 +142,28 @@ const (
 	JobResultFailure = "failure"
 )
 
+const (
+	JobFailureReasonOther      = "other"
+	JobFailureReasonTimeout    = "timeout"
+	JobFailureReasonCancelled  = "cancelled"
+	JobFailureReasonWorkerLost = "worker_lost"
+)
+
 type JobResult struct {
 	JobID      string `msgpack:"id"`
 	Type       string `msgpack:"type"`
 	FinishedAt string `msgpack:"finished_at"`
 
 	// When type == JobResultSuccess
-	Result map[string]interface{} `msgpack:"result"`
+	Result interface{} `msgpack:"result"`
 
 	// When type == JobResultFailure
 	Reason      string      `msgpack:"reason"`
 	ShouldRetry bool        `msgpack:"should_retry"`
 	Error       interface{} `msgpack:"error,omitempty"`
 	Message     string      `msgpack:"message"`
+
+	WorkerID string `msgpack:"worker_id,omitempty"` // worker which has executed the job
 }
 
 func (c *Conn) PollNewResult(ctx context.Context, resultChan chan<- JobResult) {
@@ -107,6 +171,9 @@ func (c *Conn) PollNewResult(ctx context.Context, resultChan chan<- JobResult) {
 	for {
 		s, err := c.r.BLPop(ctx, 0, RResultQueue).Result()
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			panic(err)
 		}
 		resultByte := s[1]
@@ -117,6 +184,11 @@ func (c *Conn) PollNewResult(ctx context.Context, resultChan chan<- JobResult) {
 		}
 
 		log.Printf("job (%s) received result", jobResult.JobID)
-		resultChan <- jobResult
+		select {
+		case resultChan <- jobResult:
+		case <-ctx.Done():
+			c.putBack(RResultQueue, resultByte)
+			return
+		}
 	}
 }
@@ -0,0 +1,72 @@
+package transport_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightpub-dev/lightjq/jq-master/internal/testutil"
+	"github.com/lightpub-dev/lightjq/jq-master/transport"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestScheduledAt(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	runAt, err := transport.JobRegisterRequest{}.ScheduledAt(now)
+	if err != nil || !runAt.IsZero() {
+		t.Errorf("expected unscheduled job, got %v (%v)", runAt, err)
+	}
+
+	runAt, err = transport.JobRegisterRequest{Delay: 7200}.ScheduledAt(now)
+	if err != nil || !runAt.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("expected job delayed by 2 hours, got %v (%v)", runAt, err)
+	}
+
+	runAt, err = transport.JobRegisterRequest{RunAt: "2024-05-02T09:00:00+09:00", Delay: 10}.ScheduledAt(now)
+	if err != nil || !runAt.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected run_at to take precedence, got %v (%v)", runAt, err)
+	}
+
+	if _, err := (transport.JobRegisterRequest{RunAt: "tomorrow"}).ScheduledAt(now); err == nil {
+		t.Error("expected an error for an invalid run_at")
+	}
+}
+
+func TestPollPutsBackItemsNotHandedOver(t *testing.T) {
+	r := testutil.SetupRedis(t)
+	conn := transport.NewConn(r)
+	r.Del(context.Background(), transport.RJobList)
+	t.Cleanup(func() {
+		r.Del(context.Background(), transport.RJobList)
+	})
+
+	jobBin, err := msgpack.Marshal(transport.JobRegisterRequest{ID: "handed-over", Name: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RPush(context.Background(), transport.RJobList, jobBin).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the term ends while nobody takes the popped job
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		conn.PollNewJob(ctx, make(chan transport.JobRegisterRequest))
+		close(done)
+	}()
+	for r.LLen(context.Background(), transport.RJobList).Val() != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	items, err := r.LRange(context.Background(), transport.RJobList, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0] != string(jobBin) {
+		t.Errorf("expected the job to be put back, got %q", items)
+	}
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
@@ -13,19 +14,25 @@ const (
 	ResultQueue         = "jq:resultQueue"
 	JobRegisterQueue    = "jq:jobList"
 	ProcessingSet       = "jq:processing"
+	CancelChannel       = "jq:cancel"
+	InFlightPrefix      = "jq:inflight:" // + worker id; jobs taken from the global queue and not acknowledged yet
 )
 
 type Client struct {
 	Worker Worker
 	Info   WorkerInfo
+
+	cancelsMutex sync.Mutex
+	cancels      map[string]context.CancelFunc // job id -> cancel func of the running job
 }
 
 type Worker interface {
 	Ping(ctx context.Context, workerID string) error
 	Register(ctx context.Context, info *WorkerInfo) error
 	Enqueue(ctx context.Context, job *JobInfo) error
-	Dequeue(ctx context.Context) (*JobInfo, error)
+	Dequeue(ctx context.Context, workerID string) (*JobInfo, error)
 	ReportResult(ctx context.Context, result *JobResult) error
+	WatchCancellations(ctx context.Context, onCancel func(jobID string)) error
 	Close() error
 	FlushAll() error
 }
@@ -50,6 +57,21 @@ func (p *PingMessage) Decode(data []byte) error {
 	return decodeMsg(data, p)
 }
 
+// CancelMessage represents a request from master to stop a running job.
+type CancelMessage struct {
+	JobID string `msgpack:"job_id"`
+}
+
+// Encode encodes the CancelMessage struct into a byte slice.
+func (c *CancelMessage) Encode() ([]byte, error) {
+	return encodeMsg(c)
+}
+
+// Decode decodes the byte slice into a CancelMessage struct.
+func (c *CancelMessage) Decode(data []byte) error {
+	return decodeMsg(data, c)
+}
+
 // WorkerInfo represents information about a worker.
 type WorkerInfo struct {
 	Id        string `msgpack:"id"`          // unique identifier for the worker (e.g., UUID v7)
@@ -128,6 +150,7 @@ const (
 	JobFailureReasonTimeout     JobFailureReason = "timeout"
 	JobFailureReasonServerIssue JobFailureReason = "server_issue"
 	JobFailureReasonUnknown     JobFailureReason = "unknown"
+	JobFailureReasonCancelled   JobFailureReason = "cancelled"
 )
 
 type JobResult struct {
@@ -143,4 +166,6 @@ type JobResult struct {
 	ShouldRetry bool             `msgpack:"should_retry"`
 	Error       interface{}      `msgpack:"error,omitempty"`
 	Message     string           `msgpack:"message"`
+
+	WorkerID string `msgpack:"worker_id,omitempty"` // filled in by the client when reporting
 }
@@ -72,7 +72,7 @@ func NewClient(redisOpt RedisOpt, opts ...ClientOption) *Client {
 		opt.apply(&info)
 	}
 
-	rdbClient := Client{Worker: RedisConn{client}, Info: info}
+	rdbClient := Client{Worker: RedisConn{client}, Info: info, cancels: make(map[string]context.CancelFunc)}
 
 	// send a ping message to the master
 	go func() {
@@ -89,6 +89,17 @@ func NewClient(redisOpt RedisOpt, opts ...ClientOption) *Client {
 		}
 	}()
 
+	// stop running jobs when master cancels them
+	go func() {
+		for {
+			err := rdbClient.Worker.WatchCancellations(context.Background(), rdbClient.cancelJob)
+			if err == nil {
+				return
+			}
+			time.Sleep(DefaultPingInterval)
+		}
+	}()
+
 	return &rdbClient
 }
 
@@ -115,9 +126,37 @@ func (c *Client) Enqueue(ctx context.Context, job *JobInfo) error {
 }
 
 func (c *Client) Dequeue(ctx context.Context) (*JobInfo, error) {
-	return c.Worker.Dequeue(ctx)
+	return c.Worker.Dequeue(ctx, c.Info.Id)
 }
 
 func (c *Client) ReportResult(ctx context.Context, result *JobResult) error {
+	result.WorkerID = c.Info.Id
 	return c.Worker.ReportResult(ctx, result)
 }
+
+// JobContext returns a context that is cancelled when master cancels the job.
+// done must be called once the job has finished.
+func (c *Client) JobContext(parent context.Context, jobID string) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	c.cancelsMutex.Lock()
+	c.cancels[jobID] = cancel
+	c.cancelsMutex.Unlock()
+
+	return ctx, func() {
+		c.cancelsMutex.Lock()
+		delete(c.cancels, jobID)
+		c.cancelsMutex.Unlock()
+		cancel()
+	}
+}
+
+func (c *Client) cancelJob(jobID string) {
+	c.cancelsMutex.Lock()
+	cancel, ok := c.cancels[jobID]
+	c.cancelsMutex.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -56,18 +57,27 @@ func (r RedisConn) Enqueue(ctx context.Context, job *JobInfo) error {
 	return r.Client.RPush(ctx, JobRegisterQueue, encMsg).Err()
 }
 
-func (r RedisConn) Dequeue(ctx context.Context) (*JobInfo, error) {
+func (r RedisConn) Dequeue(ctx context.Context, workerID string) (*JobInfo, error) {
 	startedAt := time.Now().Format(time.RFC3339)
 
-	// 1. Pop a job from the global queue
-	encMsg, err := r.Client.BLPop(ctx, 0, GlobalQueue).Result()
+	// 1. Move a job from the global queue to our in-flight list,
+	// so that the master can recover it if this worker dies before reporting;
+	// the master learns that we run the job from that list
+	jobID, err := r.Client.BLMove(ctx, GlobalQueue, InFlightPrefix+workerID, "LEFT", "RIGHT", 0).Result()
 	if err != nil {
 		return nil, err
 	}
 
 	// 2. Get the job from the job queue
-	jobId := JobQueuePrefix + encMsg[1]
+	jobId := JobQueuePrefix + jobID
 	jobEnc, err := r.Client.Get(ctx, jobId).Result()
+	if errors.Is(err, redis.Nil) {
+		// the job is gone (e.g. cancelled); there is nothing to acknowledge later
+		if err := r.Client.LRem(ctx, InFlightPrefix+workerID, 0, jobID).Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("job %s not found", jobID)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -139,8 +149,37 @@ func (r RedisConn) ReportResult(ctx context.Context, result *JobResult) error {
 		return err
 	}
 
-	// 3. Push the result to the result queue
-	return r.Client.RPush(ctx, ResultQueue, encMsg).Err()
+	// 3. Push the result to the result queue and acknowledge the job at once
+	tx := r.Client.TxPipeline()
+	tx.RPush(ctx, ResultQueue, encMsg)
+	if result.WorkerID != "" {
+		tx.LRem(ctx, InFlightPrefix+result.WorkerID, 0, result.JobID)
+	}
+	_, err = tx.Exec(ctx)
+	return err
+}
+
+// WatchCancellations calls onCancel for every cancellation request sent by master until ctx is done.
+func (r RedisConn) WatchCancellations(ctx context.Context, onCancel func(jobID string)) error {
+	sub := r.Client.Subscribe(ctx, CancelChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var cancelMsg CancelMessage
+			if err := cancelMsg.Decode([]byte(msg.Payload)); err != nil {
+				continue
+			}
+			onCancel(cancelMsg.JobID)
+		}
+	}
 }
 
 func (r RedisConn) FlushAll() error {
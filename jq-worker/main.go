@@ -63,7 +63,9 @@ func main() {
 					}
 
 					var jobResult internal.JobResult
-					err = doProcess(job, &jobResult)
+					jobCtx, done := client.JobContext(context.Background(), job.Id)
+					err = doProcess(jobCtx, job, &jobResult)
+					done()
 					if err != nil {
 						fmt.Println(err)
 						continue
@@ -90,11 +92,22 @@ func getJob(client *internal.Client) (*internal.JobInfo, error) {
 	return job, nil
 }
 
-func doProcess(job *internal.JobInfo, result *internal.JobResult) error {
+func doProcess(ctx context.Context, job *internal.JobInfo, result *internal.JobResult) error {
 	fmt.Printf("Processing job: %s\n", job.Name)
-	// random between 1 ~ 3 seconds
-	time.Sleep(time.Duration(1+time.Now().UnixNano()%3) * time.Second)
 	result.JobID = job.Id
+
+	// random between 1 ~ 3 seconds
+	select {
+	case <-time.After(time.Duration(1+time.Now().UnixNano()%3) * time.Second):
+	case <-ctx.Done():
+		// master cancelled the job
+		result.FinishedAt = time.Now().Format(time.RFC3339)
+		result.Type = internal.JobResultStatusFailure
+		result.Reason = internal.JobFailureReasonCancelled
+		result.Message = "job cancelled"
+		fmt.Printf("Cancelled job: %s\n", job.Name)
+		return nil
+	}
 	result.FinishedAt = time.Now().Format(time.RFC3339)
 
 	// random error